@@ -0,0 +1,84 @@
+package gate
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptInterval is how often adaptive gate reconsiders its capacity.
+const adaptInterval = time.Second
+
+// adaptive adjusts gate capacity to keep task latency near target.
+type adaptive struct {
+	min, max int
+	target   time.Duration
+
+	mu       sync.Mutex
+	total    time.Duration // sum of task durations since the last adjustment
+	count    int           // number of tasks since the last adjustment
+	last     time.Time     // time of the last adjustment
+	resizing bool
+}
+
+// NewAdaptive returns new Gate which adjusts its capacity between min and max
+// to keep duration of tasks run with Do and DoErr near target. Gate starts
+// with capacity min. Once per second, on completion of a task, it compares
+// mean duration of tasks completed since the previous adjustment with
+// target: if tasks were slower, capacity is decreased by a quarter (but at
+// least by one), if they were faster, capacity is increased by one. This is
+// the additive increase, multiplicative decrease scheme, which backs off fast
+// when downstream is overloaded and probes for more capacity carefully. Use
+// Cap to get the current capacity. Tasks run otherwise do not affect
+// capacity. NewAdaptive panics if min is non-positive or max is less than
+// min.
+func NewAdaptive(min, max int, target time.Duration) *Gate {
+	if min <= 0 || max < min {
+		panic("gate: invalid NewAdaptive capacity range")
+	}
+	g := New(min)
+	g.adapt = &adaptive{min: min, max: max, target: target, last: time.Now()}
+	return g
+}
+
+// record accounts a task that ran for d, adjusting capacity of g if it is
+// time to.
+func (a *adaptive) record(g *Gate, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total += d
+	a.count++
+	if a.resizing || time.Since(a.last) < adaptInterval {
+		return
+	}
+	mean := a.total / time.Duration(a.count)
+	a.total, a.count, a.last = 0, 0, time.Now()
+	cur := g.Cap()
+	next := cur
+	switch {
+	case mean > a.target:
+		next = cur * 3 / 4
+		if next == cur {
+			next--
+		}
+	case mean < a.target:
+		next++
+	}
+	if next < a.min {
+		next = a.min
+	}
+	if next > a.max {
+		next = a.max
+	}
+	if next == cur {
+		return
+	}
+	a.resizing = true
+	// shrinking blocks until enough slots are released, which should not
+	// stall the task that triggered it
+	go func() {
+		g.Resize(next)
+		a.mu.Lock()
+		a.resizing = false
+		a.mu.Unlock()
+	}()
+}
@@ -0,0 +1,44 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptive(t *testing.T) {
+	// expire adjustment interval without waiting for it
+	expire := func(g *Gate) {
+		g.adapt.mu.Lock()
+		g.adapt.last = time.Now().Add(-adaptInterval)
+		g.adapt.mu.Unlock()
+	}
+	waitCap := func(g *Gate, want int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); g.Cap() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("adaptive gate capacity is %d, want %d", g.Cap(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	g := NewAdaptive(2, 3, time.Hour)
+	if n := g.Cap(); n != 2 {
+		t.Fatalf("adaptive gate starts with capacity %d, want 2", n)
+	}
+	g.Do(func() {})
+	if n := g.Cap(); n != 2 {
+		t.Fatalf("capacity changed to %d before adjustment interval passed", n)
+	}
+	for i := 0; i < 2; i++ {
+		expire(g)
+		g.Do(func() {})
+		waitCap(g, 3) // fast tasks grow capacity up to max
+	}
+
+	g = NewAdaptive(1, 8, time.Nanosecond)
+	g.Resize(8)
+	expire(g)
+	g.Do(func() { time.Sleep(time.Millisecond) })
+	waitCap(g, 6) // slow tasks shrink capacity by a quarter
+}
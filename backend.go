@@ -0,0 +1,113 @@
+package gate
+
+// Backend is a limiter which a Gate created by NewWithBackend delegates slot
+// acquisition to, e.g. a distributed semaphore shared by several processes.
+// Its methods must be safe for concurrent use.
+type Backend interface {
+	// Acquire blocks until it acquires a slot and returns true, or until
+	// done is closed, returning false. A nil done is never closed. Backends
+	// that can fail, like remote ones, should retry until done is closed.
+	Acquire(done <-chan struct{}) bool
+	// TryAcquire acquires a slot if it can be done without blocking and
+	// reports whether it succeeded.
+	TryAcquire() bool
+	// Release releases a slot acquired with Acquire or TryAcquire.
+	Release()
+}
+
+// NewWithBackend returns new Gate which delegates limiting of concurrency to
+// b, while keeping the Gate API, so code using the gate does not depend on
+// where the limit is enforced. Every acquired slot is acquired from b, and
+// every released slot is released to it. Gate itself tracks slots it holds
+// like the one returned by NewUnlimited does, so Len, Wait and statistics
+// only account slots held through this gate, Cap returns -1, Available
+// returns math.MaxInt, Resize returns an error and C panics. Gates returned
+// by New and other constructors keep using a local channel and are not
+// affected. NewWithBackend panics if b is nil.
+func NewWithBackend(b Backend) *Gate {
+	if b == nil {
+		panic("gate: nil backend")
+	}
+	g := NewUnlimited()
+	g.unl.backend = b
+	return g
+}
+
+// acquire acquires n slots from backend and accounts them, giving up and
+// returning false once done is closed, in which case no slots stay acquired.
+func (c *counter) acquire(n int, done <-chan struct{}) bool {
+	if c.backend != nil {
+		for i := 0; i < n; i++ {
+			if !c.backend.Acquire(done) {
+				c.releaseBackend(i)
+				return false
+			}
+		}
+	}
+	c.add(n)
+	return true
+}
+
+// tryAcquire is like acquire, but does not block, reporting whether all n
+// slots were acquired.
+func (c *counter) tryAcquire(n int) bool {
+	if c.backend != nil {
+		if got := c.tryBackend(n); got < n {
+			c.releaseBackend(got)
+			return false
+		}
+	}
+	c.add(n)
+	return true
+}
+
+// tryAcquireBelow acquires a single slot without blocking if fewer than limit
+// slots are held, checking and incrementing counter atomically, and reports
+// whether it succeeded.
+func (c *counter) tryAcquireBelow(limit int) bool {
+	if c.backend != nil && !c.backend.TryAcquire() {
+		return false
+	}
+	c.mu.Lock()
+	if c.n >= limit {
+		c.mu.Unlock()
+		c.releaseBackend(1)
+		return false
+	}
+	c.set(c.n + 1)
+	c.mu.Unlock()
+	return true
+}
+
+// acquireUpTo acquires and accounts as many as n slots as it can without
+// blocking and returns their number.
+func (c *counter) acquireUpTo(n int) int {
+	got := n
+	if c.backend != nil {
+		got = c.tryBackend(n)
+	}
+	if got > 0 {
+		c.add(got)
+	}
+	return got
+}
+
+// tryBackend acquires up to n slots from backend without blocking and
+// returns their number.
+func (c *counter) tryBackend(n int) int {
+	got := 0
+	for got < n && c.backend.TryAcquire() {
+		got++
+	}
+	return got
+}
+
+// releaseBackend releases n slots to backend, if counter has one.
+func (c *counter) releaseBackend(n int) {
+	if c.backend == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.backend.Release()
+	}
+}
@@ -0,0 +1,68 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// chanBackend is a Backend built on a buffered channel, like the one Gate
+// uses by default.
+type chanBackend chan struct{}
+
+func (b chanBackend) Acquire(done <-chan struct{}) bool {
+	select {
+	case b <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (b chanBackend) TryAcquire() bool {
+	select {
+	case b <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b chanBackend) Release() { <-b }
+
+func TestBackend(t *testing.T) {
+	b := make(chanBackend, 2)
+	g := NewWithBackend(b)
+	g.Lock()
+	if !g.TryLock() {
+		t.Fatal("TryLock failed with free backend slot")
+	}
+	if g.TryLock() || g.TryAdd(1) {
+		t.Fatal("acquisition succeeded with full backend")
+	}
+	if g.LockTimeout(10 * time.Millisecond) {
+		t.Fatal("LockTimeout succeeded with full backend")
+	}
+	if n := len(b); n != 2 {
+		t.Fatalf("backend holds %d slots, want 2", n)
+	}
+	g.Unlock()
+	g.Unlock()
+	if n := len(b); n != 0 || g.Len() != 0 {
+		t.Fatalf("backend holds %d slots and gate %d after release, want 0", n, g.Len())
+	}
+}
+
+func TestBackendAddContext(t *testing.T) {
+	b := make(chanBackend, 2)
+	g := NewWithBackend(b)
+	g.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.AddContext(ctx, 2); err != context.DeadlineExceeded {
+		t.Fatalf("AddContext returned %v, want context.DeadlineExceeded", err)
+	}
+	if n := len(b); n != 1 || g.Len() != 1 {
+		t.Fatalf("backend holds %d slots and gate %d after canceled AddContext, want 1", n, g.Len())
+	}
+}
@@ -0,0 +1,44 @@
+package gate
+
+import (
+	"sync"
+	"testing"
+)
+
+// Capacity 1 gate is not special-cased with an atomic fast path: Len, Wait,
+// Drain, Resize and the channel returned by C all observe slots held in the
+// channel, so a slot taken outside of it would break them. These benchmarks
+// compare the existing paths with sync.Mutex.
+
+func BenchmarkLockUnlockCap1(b *testing.B) {
+	g := New(1)
+	for i := 0; i < b.N; i++ {
+		g.Lock()
+		g.Unlock()
+	}
+}
+
+func BenchmarkTryLockUnlockCap1(b *testing.B) {
+	g := New(1)
+	for i := 0; i < b.N; i++ {
+		if g.TryLock() {
+			g.Unlock()
+		}
+	}
+}
+
+func BenchmarkLockFastCap1(b *testing.B) {
+	g := New(1)
+	for i := 0; i < b.N; i++ {
+		g.LockFast()
+		g.UnlockFast()
+	}
+}
+
+func BenchmarkMutex(b *testing.B) {
+	var mu sync.Mutex
+	for i := 0; i < b.N; i++ {
+		mu.Lock()
+		mu.Unlock()
+	}
+}
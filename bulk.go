@@ -0,0 +1,86 @@
+package gate
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NewOrderedBulk returns new Gate with provided capacity on which a blocked
+// Add with positive argument has priority over single slot acquisitions with
+// Lock and its variants: while such Add is pending, single slot acquisitions
+// do not take free slots, and those already blocked hand slots they get back,
+// so slots freed by releases go to Add until it acquires all of them as a
+// unit. Pending Add calls are served one at a time. Acquisitions which took a
+// slot before Add started are not affected, and TryLock fails while Add is
+// pending. Compared to the gate returned by New, every acquisition pays for
+// an extra atomic load, and single slot acquisitions blocked during Add are
+// woken up more often. If capacity is non-positive, NewOrderedBulk would
+// panic.
+func NewOrderedBulk(max int) *Gate {
+	g := New(max)
+	g.bulk = new(bulkOrder)
+	return g
+}
+
+// bulkOrder tracks pending bulk acquisitions which single slot acquisitions
+// step aside for.
+type bulkOrder struct {
+	pending atomic.Int64 // number of pending bulk acquisitions
+
+	mu    sync.Mutex
+	clear chan struct{} // closed once pending drops to zero
+}
+
+// busy reports whether some bulk acquisition is pending. It is safe to call
+// on nil bulkOrder.
+func (b *bulkOrder) busy() bool { return b != nil && b.pending.Load() > 0 }
+
+// enter accounts a pending bulk acquisition.
+func (b *bulkOrder) enter() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending.Add(1) == 1 {
+		b.clear = make(chan struct{})
+	}
+}
+
+// leave accounts completion of a bulk acquisition accounted with enter.
+func (b *bulkOrder) leave() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending.Add(-1) == 0 {
+		close(b.clear)
+	}
+}
+
+// wait blocks while bulk acquisitions are pending, giving up and returning
+// false once done is closed.
+func (b *bulkOrder) wait(done <-chan struct{}) bool {
+	b.mu.Lock()
+	if b.pending.Load() == 0 {
+		b.mu.Unlock()
+		return true
+	}
+	clear := b.clear
+	b.mu.Unlock()
+	select {
+	case <-clear:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// bulkBlock is like block, but waits while bulk acquisitions are pending and
+// hands acquired slot back if one started in the meantime.
+func (g *Gate) bulkBlock(done <-chan struct{}) bool {
+	for {
+		if !g.bulk.wait(done) || !g.block(done) {
+			return false
+		}
+		if !g.bulk.busy() {
+			return true
+		}
+		g.release(1)
+	}
+}
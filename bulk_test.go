@@ -0,0 +1,41 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderedBulk(t *testing.T) {
+	g := NewOrderedBulk(2)
+	g.Add(2)
+	added := make(chan struct{})
+	go func() {
+		g.Add(2)
+		close(added)
+	}()
+	for !g.bulk.busy() {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		go func() {
+			g.Lock()
+			g.Unlock()
+		}()
+	}
+	for g.Blocked() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	if g.TryLock() {
+		t.Fatal("TryLock succeeded while Add is pending")
+	}
+	g.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	g.Unlock()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add did not complete after slots were released")
+	}
+	g.Add(-2)
+	g.Wait()
+}
@@ -0,0 +1,109 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ByteGate limits total size of data in flight rather than number of
+// concurrent holders: each acquisition takes a number of bytes out of a fixed
+// budget. Waiters are served in their arrival order, so large acquisitions
+// are not starved by a stream of small ones.
+type ByteGate struct {
+	mu      sync.Mutex
+	max     int64
+	held    int64
+	waiting []*byteWaiter
+}
+
+type byteWaiter struct {
+	n     int64
+	ready chan struct{} // closed once bytes are acquired for waiter
+}
+
+// NewByteGate returns new ByteGate with budget of maxBytes. If maxBytes is
+// non-positive, NewByteGate would panic.
+func NewByteGate(maxBytes int64) *ByteGate {
+	if maxBytes <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	return &ByteGate{max: maxBytes}
+}
+
+// Acquire blocks until n bytes of budget are free and acquires them, or until
+// ctx is done, returning ctx.Err(). Acquired bytes should be returned with
+// Release(n). If n is negative or larger than the whole budget, Acquire
+// returns an error wrapping ErrCapacity instead of blocking forever.
+func (bg *ByteGate) Acquire(ctx context.Context, n int64) error {
+	if n < 0 || n > bg.max {
+		return fmt.Errorf("%w: cannot acquire %d bytes of %d", ErrCapacity, n, bg.max)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bg.mu.Lock()
+	if bg.held+n <= bg.max && len(bg.waiting) == 0 {
+		bg.held += n
+		bg.mu.Unlock()
+		return nil
+	}
+	w := &byteWaiter{n: n, ready: make(chan struct{})}
+	bg.waiting = append(bg.waiting, w)
+	bg.mu.Unlock()
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	select {
+	case <-w.ready:
+		// acquired concurrently with ctx cancellation, give bytes back
+		bg.held -= n
+	default:
+		for i, v := range bg.waiting {
+			if v == w {
+				bg.waiting = append(bg.waiting[:i], bg.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	bg.notify()
+	return ctx.Err()
+}
+
+// Release returns n bytes acquired with Acquire to the budget. Releasing more
+// bytes than currently held panics.
+func (bg *ByteGate) Release(n int64) {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	if n < 0 || n > bg.held {
+		panic("gate: release of unheld bytes")
+	}
+	bg.held -= n
+	bg.notify()
+}
+
+// Len returns number of currently held bytes.
+func (bg *ByteGate) Len() int64 {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	return bg.held
+}
+
+// notify hands bytes over to waiters in arrival order while budget allows,
+// bg.mu must be held.
+func (bg *ByteGate) notify() {
+	for len(bg.waiting) > 0 {
+		w := bg.waiting[0]
+		if bg.held+w.n > bg.max {
+			return
+		}
+		bg.held += w.n
+		bg.waiting[0] = nil
+		bg.waiting = bg.waiting[1:]
+		close(w.ready)
+	}
+}
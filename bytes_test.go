@@ -0,0 +1,34 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestByteGate(t *testing.T) {
+	bg := NewByteGate(10)
+	ctx := context.Background()
+	if err := bg.Acquire(ctx, 11); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Acquire over budget returned %v, want ErrCapacity", err)
+	}
+	if err := bg.Acquire(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := bg.Acquire(tctx, 4); err != context.DeadlineExceeded {
+		t.Fatalf("Acquire returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	done := make(chan error)
+	go func() { done <- bg.Acquire(ctx, 5) }()
+	time.Sleep(10 * time.Millisecond)
+	bg.Release(7)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := bg.Len(); n != 5 {
+		t.Fatalf("ByteGate holds %d bytes, want 5", n)
+	}
+}
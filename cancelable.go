@@ -0,0 +1,58 @@
+package gate
+
+import (
+	"context"
+	"sync"
+)
+
+// cancelable is a slot held by LockCancelable.
+type cancelable struct {
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// LockCancelable locks gate like Lock does, and returns a context, which is
+// canceled by CancelOldest or by release, and a function which releases the
+// slot. Only the first call of release has effect. Cancellation is
+// cooperative: it signals the holder through ctx, but the slot stays held
+// until the holder calls release.
+func (g *Gate) LockCancelable() (ctx context.Context, release func()) {
+	g.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cancelable{cancel: cancel}
+	g.cancMu.Lock()
+	g.cancelables = append(g.cancelables, c)
+	g.cancMu.Unlock()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			g.cancMu.Lock()
+			for i, v := range g.cancelables {
+				if v == c {
+					g.cancelables = append(g.cancelables[:i], g.cancelables[i+1:]...)
+					break
+				}
+			}
+			g.cancMu.Unlock()
+			cancel()
+			g.Unlock()
+		})
+	}
+}
+
+// CancelOldest cancels context of the longest held slot acquired with
+// LockCancelable whose context was not canceled yet, e.g. to shed the oldest
+// in-flight request under overload. It reports whether any context was
+// canceled.
+func (g *Gate) CancelOldest() bool {
+	g.cancMu.Lock()
+	defer g.cancMu.Unlock()
+	for _, c := range g.cancelables {
+		if !c.canceled {
+			c.canceled = true
+			c.cancel()
+			return true
+		}
+	}
+	return false
+}
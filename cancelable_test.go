@@ -0,0 +1,27 @@
+package gate
+
+import "testing"
+
+func TestCancelOldest(t *testing.T) {
+	g := New(3)
+	ctx1, release1 := g.LockCancelable()
+	ctx2, release2 := g.LockCancelable()
+	if !g.CancelOldest() || ctx1.Err() == nil || ctx2.Err() != nil {
+		t.Fatal("CancelOldest did not cancel the oldest slot only")
+	}
+	if n := g.Len(); n != 2 {
+		t.Fatalf("gate holds %d slots after CancelOldest, want 2", n)
+	}
+	release1()
+	if !g.CancelOldest() || ctx2.Err() == nil {
+		t.Fatal("CancelOldest did not cancel the next oldest slot")
+	}
+	if g.CancelOldest() {
+		t.Fatal("CancelOldest reported cancellation with all contexts canceled")
+	}
+	release2()
+	release2()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after release, want 0", n)
+	}
+}
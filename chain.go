@@ -0,0 +1,25 @@
+package gate
+
+// Chain runs multi-stage tasks where each stage is limited by its own gate.
+type Chain struct {
+	gates []*Gate
+}
+
+// NewChain returns new Chain with a stage per each of gates, in order.
+func NewChain(gates ...*Gate) *Chain {
+	return &Chain{gates: append([]*Gate(nil), gates...)}
+}
+
+// Run calls stages in order, each with the gate of matching chain stage
+// locked. Gate of a stage is unlocked before gate of the next stage is
+// locked, so task never holds slots of two stages at once, and stages can not
+// deadlock each other. Run panics if number of stages differs from number of
+// gates chain was created with.
+func (c *Chain) Run(stages ...func()) {
+	if len(stages) != len(c.gates) {
+		panic("gate: number of stages does not match chain length")
+	}
+	for i, fn := range stages {
+		c.gates[i].Do(fn)
+	}
+}
@@ -0,0 +1,15 @@
+package gate
+
+import "testing"
+
+func TestChain(t *testing.T) {
+	a, b := New(1), New(1)
+	var held []int
+	NewChain(a, b).Run(
+		func() { held = append(held, a.Len(), b.Len()) },
+		func() { held = append(held, a.Len(), b.Len()) },
+	)
+	if len(held) != 4 || held[0] != 1 || held[1] != 0 || held[2] != 0 || held[3] != 1 {
+		t.Fatalf("held slots while running stages: %v, want [1 0 0 1]", held)
+	}
+}
@@ -0,0 +1,41 @@
+package gate
+
+// NewChild returns new Gate with capacity localMax, every slot of which also
+// holds a slot of parent gate. This caps concurrency on two levels: each child
+// allows no more than localMax holders, and all children of the same parent
+// together allow no more than parent capacity, e.g. each tenant gets N slots,
+// but the whole system is capped at M.
+//
+// Child always acquires a local slot first and a parent slot second, and
+// releases them in reverse order. If acquisition of a parent slot is canceled,
+// local slot is released. Since goroutines waiting for parent slots already
+// hold only local slots, siblings can not deadlock each other; deadlock is
+// possible only if goroutine holding a parent slot directly locks its child.
+// LockFast, UnlockFast, Reset and the channel returned by C only operate on
+// local slots and must not be mixed with other methods on a child gate.
+func NewChild(parent *Gate, localMax int) *Gate {
+	if parent == nil {
+		panic("gate: nil parent")
+	}
+	g := New(localMax)
+	g.parent = parent
+	return g
+}
+
+// lockParent acquires a parent slot for just acquired local slot if gate has
+// a parent. If done is closed first, it releases the local slot and returns
+// false.
+func (g *Gate) lockParent(done <-chan struct{}) bool {
+	if g.parent == nil || g.parent.lock(done) {
+		return true
+	}
+	g.release(1)
+	return false
+}
+
+// releaseParent releases n parent slots if gate has a parent.
+func (g *Gate) releaseParent(n int) {
+	if g.parent != nil {
+		g.parent.Release(n)
+	}
+}
@@ -0,0 +1,35 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChildParentCancelRollback(t *testing.T) {
+	parent := New(1)
+	parent.Lock()
+	defer parent.Unlock()
+	child := NewChild(parent, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := child.LockContext(ctx); err == nil {
+		t.Fatal("LockContext succeeded on a full parent")
+	}
+	if n := child.Len(); n != 0 {
+		t.Fatalf("child holds %d slots after canceled LockContext, want 0", n)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := child.AcquireContext(ctx, 2); err == nil {
+		t.Fatal("AcquireContext succeeded on a full parent")
+	}
+	if n := child.Len(); n != 0 {
+		t.Fatalf("child holds %d slots after canceled AcquireContext, want 0", n)
+	}
+	if n := parent.Len(); n != 1 {
+		t.Fatalf("parent holds %d slots, want 1", n)
+	}
+}
@@ -0,0 +1,29 @@
+package gate
+
+import "context"
+
+type ctxKey struct{}
+
+// WithGate returns a copy of ctx carrying g, which can be retrieved with
+// FromContext. It allows middleware to install request-scoped gate once,
+// without passing it through every function signature.
+func WithGate(ctx context.Context, g *Gate) context.Context {
+	return context.WithValue(ctx, ctxKey{}, g)
+}
+
+// FromContext returns gate stored in ctx by WithGate, if any.
+func FromContext(ctx context.Context) (*Gate, bool) {
+	g, ok := ctx.Value(ctxKey{}).(*Gate)
+	return g, ok && g != nil
+}
+
+// DoCtx calls fn with gate stored in ctx by WithGate locked, or with default
+// gate locked if ctx carries no gate, see Gate.Do. DoCtx does not give up
+// once ctx is done; use FromContext and Gate.DoContext for that.
+func DoCtx(ctx context.Context, fn func()) {
+	g, ok := FromContext(ctx)
+	if !ok {
+		g = defaultGate.Load()
+	}
+	g.Do(fn)
+}
@@ -0,0 +1,22 @@
+package gate
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDoCtx(t *testing.T) {
+	g := New(1)
+	ctx := WithGate(context.Background(), g)
+	if got, ok := FromContext(ctx); !ok || got != g {
+		t.Fatal("FromContext did not return gate stored by WithGate")
+	}
+	DoCtx(ctx, func() {
+		if n := g.Len(); n != 1 {
+			t.Errorf("gate from context holds %d slots while running fn, want 1", n)
+		}
+	})
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("FromContext reported gate in empty context")
+	}
+}
@@ -0,0 +1,197 @@
+package gate
+
+import (
+	"context"
+	"sync"
+)
+
+// Each calls fn for every element of items, running no more than max calls
+// concurrently, each in its own goroutine. It returns once all calls return.
+// If max is non-positive, Each would panic.
+func Each[T any](max int, items []T, fn func(T)) {
+	if len(items) == 0 {
+		checkConcurrency(max)
+		return
+	}
+	g := batchGate(max, len(items))
+	for _, item := range items {
+		item := item
+		g.Go(func() { fn(item) })
+	}
+	g.Wait()
+}
+
+// EachErr calls fn for every element of items like Each does, and returns the
+// first non-nil error returned by fn. Once fn returns an error, no new calls
+// are started, but calls that are already running are allowed to complete
+// before EachErr returns. If max is non-positive, EachErr would panic.
+func EachErr[T any](max int, items []T, fn func(T) error) error {
+	if len(items) == 0 {
+		checkConcurrency(max)
+		return nil
+	}
+	g := batchGate(max, len(items))
+	var once sync.Once
+	var err error
+	failed := make(chan struct{})
+	for _, item := range items {
+		g.Lock()
+		select {
+		case <-failed:
+			g.Unlock()
+			g.Wait()
+			return err
+		default:
+		}
+		item := item
+		go func() {
+			defer g.Unlock()
+			if e := fn(item); e != nil {
+				once.Do(func() { err = e; close(failed) })
+			}
+		}()
+	}
+	g.Wait()
+	return err
+}
+
+// Map calls fn for every element of items like Each does, and returns results
+// of fn calls ordered the same way as their arguments in items. If max is
+// non-positive, Map would panic.
+func Map[T, R any](max int, items []T, fn func(T) R) []R {
+	if len(items) == 0 {
+		checkConcurrency(max)
+		return nil
+	}
+	out := make([]R, len(items))
+	g := batchGate(max, len(items))
+	for i := range items {
+		i := i
+		g.Go(func() { out[i] = fn(items[i]) })
+	}
+	g.Wait()
+	return out
+}
+
+// MapErr calls fn for every element of items like Map does, passing it a
+// context derived from ctx, and returns results ordered the same way as their
+// arguments in items, along with the first non-nil error returned by fn. Once
+// fn returns an error or ctx is done, derived context is canceled and no new
+// calls are started, but calls that are already running are allowed to
+// complete; results of calls that were not started or failed are zero values.
+// If ctx is done before all calls are started, MapErr returns ctx.Err(). If
+// max is non-positive, MapErr would panic.
+func MapErr[T, R any](ctx context.Context, max int, items []T, fn func(context.Context, T) (R, error)) ([]R, error) {
+	if len(items) == 0 {
+		checkConcurrency(max)
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := make([]R, len(items))
+	g := batchGate(max, len(items))
+	var once sync.Once
+	var err error
+	for i := range items {
+		if g.LockContext(ctx) != nil {
+			break
+		}
+		if ctx.Err() != nil {
+			// slot was freed by a failed call, which canceled ctx
+			g.Unlock()
+			break
+		}
+		i := i
+		go func() {
+			defer g.Unlock()
+			r, e := fn(ctx, items[i])
+			if e != nil {
+				once.Do(func() { err = e; cancel() })
+				return
+			}
+			out[i] = r
+		}()
+	}
+	g.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return out, err
+}
+
+// RunN calls fn(i) for every i from 0 to n-1, running no more than max calls
+// concurrently, each in its own goroutine. It returns once all calls return.
+// If n is not positive, RunN returns immediately. If max is non-positive,
+// RunN would panic.
+func RunN(max, n int, fn func(i int)) {
+	if n <= 0 {
+		checkConcurrency(max)
+		return
+	}
+	g := batchGate(max, n)
+	for i := 0; i < n; i++ {
+		i := i
+		g.Go(func() { fn(i) })
+	}
+	g.Wait()
+}
+
+// Pipeline calls produce in its own goroutine, passing it a channel to send
+// items to, and calls consume for every item sent, running no more than max
+// consume calls concurrently, each in its own goroutine. Channel is closed
+// once produce returns, so produce must not close it itself. Pipeline returns
+// once produce and all consume calls return. If max is non-positive, Pipeline
+// would panic.
+func Pipeline[T any](max int, produce func(chan<- T), consume func(T)) {
+	checkConcurrency(max)
+	g := New(max)
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		produce(ch)
+	}()
+	for item := range ch {
+		item := item
+		g.Go(func() { consume(item) })
+	}
+	g.Wait()
+}
+
+// Consume calls fn for every item received from in until in is closed,
+// running no more than max calls concurrently, each in its own goroutine. It
+// acquires a slot before receiving the next item, so no more than max items
+// are taken from in ahead of their processing. Consume returns once in is
+// closed and all calls return. If max is non-positive, Consume would panic.
+func Consume[T any](max int, in <-chan T, fn func(T)) {
+	checkConcurrency(max)
+	g := New(max)
+	for {
+		g.Lock()
+		item, ok := <-in
+		if !ok {
+			g.Unlock()
+			break
+		}
+		go func() {
+			defer g.Unlock()
+			fn(item)
+		}()
+	}
+	g.Wait()
+}
+
+// batchGate returns a gate to process n items with concurrency max, which is
+// capped at n unless there are no items.
+func batchGate(max, n int) *Gate {
+	checkConcurrency(max)
+	if max > n && n > 0 {
+		max = n
+	}
+	return New(max)
+}
+
+func checkConcurrency(max int) {
+	if max <= 0 {
+		panic("gate: non-positive concurrency")
+	}
+}
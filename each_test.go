@@ -0,0 +1,152 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	var sum atomic.Int64
+	Pipeline(3, func(ch chan<- int) {
+		for i := 1; i <= 100; i++ {
+			ch <- i
+		}
+	}, func(i int) { sum.Add(int64(i)) })
+	if n := sum.Load(); n != 5050 {
+		t.Fatalf("consumed sum is %d, want 5050", n)
+	}
+	Pipeline(3, func(chan<- int) {}, func(int) { t.Error("consume called") })
+}
+
+func TestRunN(t *testing.T) {
+	out := make([]int, 10)
+	RunN(3, len(out), func(i int) { out[i] = i + 1 })
+	for i, v := range out {
+		if v != i+1 {
+			t.Fatalf("out[%d] is %d, want %d", i, v, i+1)
+		}
+	}
+	RunN(3, 0, func(int) { t.Error("fn called for n=0") })
+}
+
+func TestConsume(t *testing.T) {
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 1; i <= 100; i++ {
+			in <- i
+		}
+	}()
+	var sum atomic.Int64
+	Consume(3, in, func(i int) { sum.Add(int64(i)) })
+	if n := sum.Load(); n != 5050 {
+		t.Fatalf("consumed sum is %d, want 5050", n)
+	}
+}
+
+func TestMapErr(t *testing.T) {
+	out, err := MapErr(context.Background(), 2, []int{1, 2, 3}, func(_ context.Context, i int) (int, error) {
+		return i * 10, nil
+	})
+	if err != nil || len(out) != 3 || out[0] != 10 || out[2] != 30 {
+		t.Fatalf("MapErr returned %v, %v; want [10 20 30], nil", out, err)
+	}
+
+	errFail := errors.New("fail")
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	var started atomic.Int64
+	out, err = MapErr(context.Background(), 1, items, func(ctx context.Context, i int) (int, error) {
+		started.Add(1)
+		if i == 1 {
+			return 0, errFail
+		}
+		return i + 1, ctx.Err()
+	})
+	if err != errFail {
+		t.Fatalf("MapErr returned %v, want %v", err, errFail)
+	}
+	if out[0] != 1 || out[1] != 0 {
+		t.Fatalf("partial results are %v, want result of the first item only", out[:2])
+	}
+	if n := started.Load(); n != 2 {
+		t.Fatalf("%d calls started, want 2", n)
+	}
+}
+
+func TestEach(t *testing.T) {
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i + 1
+	}
+	var sum, running, peak atomic.Int64
+	Each(3, items, func(i int) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(100 * time.Microsecond)
+		sum.Add(int64(i))
+	})
+	if n := sum.Load(); n != 1275 {
+		t.Fatalf("processed sum is %d, want 1275", n)
+	}
+	if n := peak.Load(); n > 3 {
+		t.Fatalf("Each ran %d calls concurrently, want no more than 3", n)
+	}
+	Each(3, []int(nil), func(int) { t.Error("fn called for no items") })
+}
+
+func TestEachErr(t *testing.T) {
+	if err := EachErr(2, []int{1, 2, 3}, func(int) error { return nil }); err != nil {
+		t.Fatalf("EachErr returned %v, want nil", err)
+	}
+	errFail := errors.New("fail")
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	var started atomic.Int64
+	err := EachErr(1, items, func(i int) error {
+		started.Add(1)
+		if i == 1 {
+			return errFail
+		}
+		return nil
+	})
+	if err != errFail {
+		t.Fatalf("EachErr returned %v, want %v", err, errFail)
+	}
+	if n := started.Load(); n != 2 {
+		t.Fatalf("%d calls started, want 2", n)
+	}
+}
+
+func TestMap(t *testing.T) {
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i
+	}
+	out := Map(4, items, func(i int) string {
+		// make later items complete first
+		time.Sleep(time.Duration(len(items)-i) * 10 * time.Microsecond)
+		return strconv.Itoa(i)
+	})
+	if len(out) != len(items) {
+		t.Fatalf("Map returned %d results, want %d", len(out), len(items))
+	}
+	for i, v := range out {
+		if v != strconv.Itoa(i) {
+			t.Fatalf("out[%d] is %q, want %q", i, v, strconv.Itoa(i))
+		}
+	}
+	if out := Map(4, []int(nil), strconv.Itoa); len(out) != 0 {
+		t.Fatalf("Map of no items returned %v", out)
+	}
+}
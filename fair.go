@@ -0,0 +1,56 @@
+package gate
+
+import (
+	"runtime"
+	"time"
+)
+
+// NewFairAfter returns new Gate with provided capacity on which goroutines
+// blocked in Lock and its variants for longer than threshold get priority
+// over others: while such starving waiters exist, new acquisitions do not
+// take free slots without waiting, and other waiters which get a slot hand it
+// back. In the common uncontended case the gate is as fast as the one returned
+// by New. Priority is best-effort, not strict ordering: starving waiters are
+// not ordered among themselves, and TryLock ignores them. If capacity is
+// non-positive, NewFairAfter would panic.
+func NewFairAfter(max int, threshold time.Duration) *Gate {
+	if threshold <= 0 {
+		panic("gate: non-positive NewFairAfter threshold")
+	}
+	g := New(max)
+	g.fairAfter = threshold
+	return g
+}
+
+// fairBlock is like block, but once it waits for longer than g.fairAfter, it
+// accounts itself as starving in g.starved, and until then it yields acquired
+// slots back if other waiters starve.
+func (g *Gate) fairBlock(done <-chan struct{}) bool {
+	t := time.NewTimer(g.fairAfter)
+	defer t.Stop()
+	var starving bool
+	defer func() {
+		if starving {
+			g.starved.Add(-1)
+		}
+	}()
+	for !g.retired.Load() {
+		c, swap := g.chans()
+		select {
+		case c <- struct{}{}:
+			if starving || g.starved.Load() == 0 {
+				return true
+			}
+			// starving waiter blocked on c takes this slot over
+			g.release(1)
+			runtime.Gosched()
+		case <-swap:
+		case <-t.C:
+			starving = true
+			g.starved.Add(1)
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
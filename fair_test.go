@@ -0,0 +1,30 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairAfter(t *testing.T) {
+	g := NewFairAfter(1, 5*time.Millisecond)
+	g.Lock()
+	done := make(chan struct{})
+	go func() {
+		g.Lock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if n := g.starved.Load(); n != 1 {
+		t.Fatalf("%d starving waiters, want 1", n)
+	}
+	g.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("starving waiter did not get released slot")
+	}
+	if n := g.starved.Load(); n != 0 {
+		t.Fatalf("%d starving waiters after acquisition, want 0", n)
+	}
+	g.Unlock()
+}
@@ -0,0 +1,79 @@
+package gate
+
+import "sync"
+
+// WithSharedCapacity makes Registry share max slots among all keys for
+// DoFair calls, on top of per-key gate capacities.
+func WithSharedCapacity(max int) RegistryOption {
+	checkConcurrency(max)
+	return func(r *Registry) { r.fair = &fairShare{free: max, waiting: make(map[string][]chan struct{})} }
+}
+
+// DoFair calls fn holding both a slot of gate for key and one of slots shared
+// among all keys, as set by WithSharedCapacity, releasing them once fn
+// returns, even if it panics. Shared slots are granted round-robin among keys
+// with pending DoFair calls: each freed slot goes to the next key in
+// rotation, and calls for the same key are served in arrival order, so a
+// flood of calls for one key does not starve others. Keys are not weighted.
+// A key which gets its first pending call is appended to the end of the
+// rotation, so it is served after all keys that were already waiting. Since
+// per-key gate slot is acquired first, a key has no more pending calls for
+// shared slots than its gate capacity. DoFair panics if registry was created
+// without WithSharedCapacity.
+func (r *Registry) DoFair(key string, fn func()) {
+	if r.fair == nil {
+		panic("gate: DoFair on Registry without shared capacity")
+	}
+	g := r.Gate(key)
+	g.Lock()
+	defer g.Unlock()
+	r.fair.acquire(key)
+	defer r.fair.release()
+	fn()
+}
+
+// fairShare grants shared slots round-robin among keys.
+type fairShare struct {
+	mu      sync.Mutex
+	free    int                        // number of free shared slots
+	waiting map[string][]chan struct{} // closed to grant a slot, by key, in arrival order
+	ring    []string                   // keys with waiting calls, in rotation order
+}
+
+// acquire blocks until a shared slot is granted to key.
+func (f *fairShare) acquire(key string) {
+	f.mu.Lock()
+	if f.free > 0 {
+		f.free--
+		f.mu.Unlock()
+		return
+	}
+	grant := make(chan struct{})
+	if len(f.waiting[key]) == 0 {
+		f.ring = append(f.ring, key)
+	}
+	f.waiting[key] = append(f.waiting[key], grant)
+	f.mu.Unlock()
+	<-grant
+}
+
+// release releases a shared slot, granting it to the next key in rotation if
+// any calls are waiting.
+func (f *fairShare) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.ring) == 0 {
+		f.free++
+		return
+	}
+	key := f.ring[0]
+	f.ring = f.ring[1:]
+	q := f.waiting[key]
+	close(q[0])
+	if q = q[1:]; len(q) > 0 {
+		f.waiting[key] = q
+		f.ring = append(f.ring, key)
+	} else {
+		delete(f.waiting, key)
+	}
+}
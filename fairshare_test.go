@@ -0,0 +1,39 @@
+package gate
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryDoFair(t *testing.T) {
+	r := NewRegistry(3, WithSharedCapacity(1))
+	release := make(chan struct{})
+	go r.DoFair("x", func() { <-release })
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(key string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.DoFair(key, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, key)
+			})
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	queue("a")
+	queue("a")
+	queue("a")
+	queue("b")
+	close(release)
+	wg.Wait()
+	if got := strings.Join(order, ""); got != "abaa" {
+		t.Fatalf("DoFair served keys in order %q, want %q", got, "abaa")
+	}
+}
@@ -0,0 +1,66 @@
+package gate
+
+import "sync"
+
+// fifo orders goroutines waiting for gate slots: only the goroutine at the
+// head of the queue blocks on the gate channel, others wait for their turn.
+type fifo struct {
+	mu      sync.Mutex
+	busy    bool            // whether some goroutine is at the head
+	waiting []chan struct{} // closed to pass the head turn, in arrival order
+}
+
+// lock acquires a slot of g in arrival order, giving up and returning false
+// once done is closed. It does not update gate statistics.
+func (q *fifo) lock(g *Gate, done <-chan struct{}) bool {
+	q.mu.Lock()
+	if !q.busy {
+		if g.trySend() {
+			q.mu.Unlock()
+			return true
+		}
+		q.busy = true
+		q.mu.Unlock()
+	} else {
+		turn := make(chan struct{})
+		q.waiting = append(q.waiting, turn)
+		q.mu.Unlock()
+		select {
+		case <-turn:
+		case <-done:
+			if !q.leave(turn) {
+				// turn was passed concurrently, hand it over further
+				q.next()
+			}
+			return false
+		}
+	}
+	defer q.next()
+	return g.block(done)
+}
+
+// next passes the head turn to the next waiting goroutine, if any.
+func (q *fifo) next() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+	close(q.waiting[0])
+	q.waiting[0] = nil
+	q.waiting = q.waiting[1:]
+}
+
+// leave removes turn from the queue and reports whether it was still there.
+func (q *fifo) leave(turn chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, t := range q.waiting {
+		if t == turn {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
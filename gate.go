@@ -3,8 +3,17 @@
 package gate
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // A Gate is a primitive intended to help in limiting concurrency in some
@@ -12,39 +21,1026 @@ import (
 // limit on its counter or a sync.Locker which allows up to max number of
 // concurrent lockers to be held.
 type Gate struct {
-	c chan struct{}
-	m sync.Mutex
+	id uint64 // unique gate id, defines locking order in LockAll
+
+	m chan struct{} // 1-buffered, serializes bulk operations like Wait or Resize
+
+	rw   sync.RWMutex  // guards c and swap, write-locked only by Resize
+	c    chan struct{} // holds one value per acquired slot
+	swap chan struct{} // closed once c is replaced by Resize
+
+	obs     WaitObserver
+	holdObs func(held time.Duration) // set by NewWithHoldObserver
+
+	slowAfter time.Duration
+	slowLog   func(label string, waited time.Duration)
+
+	fifo *fifo      // non-nil if waiters are queued in arrival order
+	bulk *bulkOrder // non-nil if pending Add has priority over Lock
+	unl  *counter   // non-nil if gate is unlimited, c is nil then
+	rate *rate      // non-nil if single slot acquisitions are rate limited
+
+	extra int       // overdraft allowed above capacity, included in cap(c)
+	adapt *adaptive // non-nil if capacity adapts to task durations
+
+	parent *Gate // non-nil if each slot also holds a slot of parent gate
+
+	softMax  int               // soft limit set by NewSoft
+	onExceed func(current int) // non-nil if gate has a soft limit
+
+	spins int // number of acquisition retries before blocking
+
+	fairAfter time.Duration // positive if long waiters get priority
+	starved   atomic.Int64  // number of waiters waiting longer than fairAfter
+
+	maxWaiters int64        // negative if LockOrReject should never reject
+	waiters    atomic.Int64 // number of goroutines waiting for a slot
+
+	subMu    sync.Mutex
+	onResize []chan int // channels returned by OnResize
+
+	pmu    sync.Mutex                    // serializes Pause and Resume
+	paused atomic.Pointer[chan struct{}] // closed by Resume, nil if not paused
+
+	preemptible atomic.Int64  // number of slots held by LockPreemptible
+	cmu         sync.Mutex    // guards contended
+	contended   chan struct{} // closed once a goroutine waits for a slot
+
+	free atomic.Pointer[chan struct{}] // if non-nil, closed once slots are released
+
+	cancMu      sync.Mutex
+	cancelables []*cancelable // slots held by LockCancelable, oldest first
+
+	wmu    sync.Mutex
+	shared chan struct{} // closed once WaitShared in progress completes
+
+	closed    chan struct{} // closed by Close
+	closeOnce sync.Once
+	retired   atomic.Bool // set by Retire
+
+	acquires atomic.Uint64
+	releases atomic.Uint64
+	waited   atomic.Int64 // total time spent waiting for slots
+	peak     atomic.Int64 // maximum number of held slots seen
+
+	rejections atomic.Uint64 // number of failed non-blocking acquisitions
+
+	addWarn     atomic.Pointer[addWarn] // set by SetDeadlockWarn
+	waitTimeout atomic.Int64            // set by SetWaitTimeout
+	strict      atomic.Bool             // set by SetStrict
+
+	excl int // number of slots held by LockExclusive
+
+	hist [histBuckets]atomic.Uint64 // acquisitions by wait time, see WaitHistogram
+
+	tee    atomic.Pointer[Gate]    // shadow gate set by Tee
+	track  atomic.Pointer[tracker] // set by SetTrackCallers
+	owners atomic.Pointer[owners]  // set by SetOwnerCheck
+
+	readyOnce sync.Once
+	ready     atomic.Pointer[chan struct{}] // 1-buffered channel returned by Ready
+
+	onRelease  atomic.Pointer[func(available int)] // set by OnRelease
+	onSaturate atomic.Pointer[func()]              // set by OnSaturate
+	scale      atomic.Pointer[ScaleFunc]           // set by SetTimeoutScale
+	saturated  atomic.Bool                         // whether onSaturate fired since gate had room
+}
+
+// ErrQueueFull is returned by LockOrReject when gate already has maximum
+// number of goroutines waiting for a slot, and by Pool.Submit when pool queue
+// is full.
+var ErrQueueFull = errors.New("gate: wait queue is full")
+
+// ErrClosed is returned by LockOrClosed when gate is closed, and by
+// Pool.Submit when pool is shut down.
+var ErrClosed = errors.New("gate: gate is closed")
+
+// ErrNotHeld is returned by UnlockSafe when gate has no held slots.
+var ErrNotHeld = errors.New("gate: unlock of unheld gate")
+
+// ErrCapacity is wrapped by errors returned when requested capacity or number
+// of slots is invalid for the gate, like by NewGate, Acquire or Resize.
+//
+// Apart from context errors and errors returned by user functions like those
+// passed to DoErr, all errors returned by this package are or wrap one of
+// ErrQueueFull, ErrClosed, ErrNotHeld, ErrCapacity and ErrPanicked, so they can
+// be checked with errors.Is.
+var ErrCapacity = errors.New("gate: invalid capacity")
+
+// ErrPanicked is wrapped by errors returned by Registry.Do to calls which
+// waited for a deduplicated call that panicked.
+var ErrPanicked = errors.New("gate: function panicked")
+
+// WaitObserver is a function called by Gate each time a slot is acquired
+// with any of Lock, TryLock, LockContext or LockTimeout methods, with the time
+// spent waiting for a free slot. WaitObserver is called from the acquiring
+// goroutine, so it should be fast.
+type WaitObserver func(waited time.Duration)
+
+var lastID atomic.Uint64 // last id assigned to a Gate
+
+var defaultGate atomic.Pointer[Gate] // gate used by package-level functions
+
+func init() {
+	defaultCap = runtime.NumCPU()
+	if n, err := strconv.Atoi(os.Getenv("GATE_DEFAULT_MAX")); err == nil && n > 0 {
+		defaultCap = n
+	}
+	defaultGate.Store(New(defaultCap))
 }
 
-var defaultGate = New(runtime.NumCPU())
+var defaultCap int // capacity default gate is created with
+
+// DefaultCap returns capacity the default gate used by package-level functions
+// is created with: the value of GATE_DEFAULT_MAX environment variable if it
+// is set to a positive integer, otherwise runtime.NumCPU(). Environment
+// variable allows to right-size the default gate e.g. in containers with CPU
+// limits, where runtime.NumCPU() overcounts; it is read once at program
+// start. Unlike Cap, DefaultCap is not affected by SetDefault.
+func DefaultCap() int { return defaultCap }
+
+// SetDefault atomically replaces default gate used by package-level functions
+// with g and returns the previous default gate, e.g. for tests to install a
+// controlled gate and restore the original one afterwards. Slots acquired
+// from the previous default gate must be released with its methods, so
+// default gate should only be replaced while it is idle. SetDefault panics if
+// g is nil.
+func SetDefault(g *Gate) *Gate {
+	if g == nil {
+		panic("gate: nil default gate")
+	}
+	return defaultGate.Swap(g)
+}
 
-// Lock locks default gate with capacity defined by runtime.NumCPU()
-func Lock() { defaultGate.Lock() }
+// Lock locks default gate with capacity defined by DefaultCap
+func Lock() { defaultGate.Load().Lock() }
 
 // Unlock unlocks default gate
-func Unlock() { defaultGate.Unlock() }
+func Unlock() { defaultGate.Load().Unlock() }
+
+// TryLock tries to lock default gate without blocking, see Gate.TryLock
+func TryLock() bool { return defaultGate.Load().TryLock() }
+
+// LockContext locks default gate unless ctx is done first, see
+// Gate.LockContext
+func LockContext(ctx context.Context) error { return defaultGate.Load().LockContext(ctx) }
+
+// LockTimeout locks default gate waiting no longer than d, see
+// Gate.LockTimeout
+func LockTimeout(d time.Duration) bool { return defaultGate.Load().LockTimeout(d) }
 
 // Add adds n to default gate counter. Absolute value of n should be no more
-// than runtime.NumCPU()
-func Add(n int) { defaultGate.Add(n) }
+// than DefaultCap()
+func Add(n int) { defaultGate.Load().Add(n) }
 
 // Done decrements default gate counter
-func Done() { defaultGate.Done() }
+func Done() { defaultGate.Load().Done() }
 
 // Wait blocks until default gate is not locked
-func Wait() { defaultGate.Wait() }
+func Wait() { defaultGate.Load().Wait() }
+
+// Do calls fn with default gate locked, see Gate.Do
+func Do(fn func()) { defaultGate.Load().Do(fn) }
+
+// TryDo calls fn with default gate locked if it can be locked without
+// blocking, see Gate.TryDo
+func TryDo(fn func()) bool { return defaultGate.Load().TryDo(fn) }
+
+// Len returns number of currently held slots of default gate, see Gate.Len
+func Len() int { return defaultGate.Load().Len() }
+
+// Cap returns capacity of default gate
+func Cap() int { return defaultGate.Load().Cap() }
 
 // New returns new Gate with provided capacity. If capacity is non-positive,
 // New would panic.
-func New(max int) *Gate { return &Gate{c: make(chan struct{}, max)} }
+func New(max int) *Gate {
+	if max <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	return newGate(make(chan struct{}, max))
+}
+
+// FromChannel returns new Gate using c to hold acquired slots, so that
+// capacity of c becomes gate capacity, and values already buffered in c are
+// treated as held slots. This eases migration from ad-hoc channel semaphores.
+// Caller must not send to or receive from c directly afterwards. FromChannel
+// panics if c is nil or unbuffered.
+func FromChannel(c chan struct{}) *Gate {
+	if cap(c) == 0 {
+		panic("gate: FromChannel called with nil or unbuffered channel")
+	}
+	return newGate(c)
+}
+
+func newGate(c chan struct{}) *Gate {
+	return &Gate{
+		id:   lastID.Add(1),
+		m:    make(chan struct{}, 1),
+		c:    c,
+		swap: make(chan struct{}),
+
+		maxWaiters: -1,
+		closed:     make(chan struct{}),
+	}
+}
+
+// NewWithObserver returns new Gate with provided capacity, which calls obs on
+// each slot acquisition. If capacity is non-positive, NewWithObserver would
+// panic.
+func NewWithObserver(max int, obs WaitObserver) *Gate {
+	g := New(max)
+	g.obs = obs
+	return g
+}
+
+// NewUnlimited returns new Gate without capacity limit: it never blocks on
+// acquiring slots, but still tracks number of held slots, so Wait blocks
+// until all of them are released. This allows to turn concurrency limiting
+// off without changing code using the gate. Cap returns -1 and Available
+// returns math.MaxInt for such gate. Resize returns an error and C panics on
+// unlimited gate.
+func NewUnlimited() *Gate {
+	g := newGate(nil)
+	g.unl = new(counter)
+	return g
+}
+
+// NewSoft returns new Gate which behaves like the one returned by
+// NewUnlimited, never blocking on acquiring slots, but calls onExceed with
+// the number of held slots whenever a slot is acquired while more than max
+// slots are held. onExceed is called from the acquiring goroutine, so it
+// should be fast. This allows to observe concurrency a service actually
+// reaches before enforcing a limit: once onExceed no longer fires, or its
+// rate is acceptable, replace NewSoft(max, onExceed) with New(max), code
+// using the gate needs no changes. If max is non-positive, NewSoft would
+// panic.
+func NewSoft(max int, onExceed func(current int)) *Gate {
+	if max <= 0 {
+		panic("gate: non-positive NewSoft capacity")
+	}
+	g := NewUnlimited()
+	g.softMax, g.onExceed = max, onExceed
+	return g
+}
+
+// NewRateLimited returns new Gate with provided capacity which, in addition to
+// limiting concurrency, spaces single slot acquisitions with Lock and its
+// variants at least minInterval apart from each other. Lock waits for its
+// turn after it acquired a slot, while TryLock fails if acquiring a slot
+// right away would violate the interval. Weighted acquisitions are not rate
+// limited. If capacity is non-positive, NewRateLimited would panic.
+func NewRateLimited(max int, minInterval time.Duration) *Gate {
+	g := New(max)
+	g.rate = &rate{interval: minInterval}
+	return g
+}
+
+// NewOverdraft returns new Gate with capacity max, which can be temporarily
+// exceeded by up to extra slots: Lock does not block until max+extra slots are
+// held. Len reports all held slots including overdraft, Available reports free
+// slots below max only, and Overdraft reports slots held above max. Wait
+// waits for all slots to be released, including overdraft ones. Resize
+// changes max keeping the same extra. If max is non-positive or extra is
+// negative, NewOverdraft would panic.
+func NewOverdraft(max, extra int) *Gate {
+	if max <= 0 || extra < 0 {
+		panic("gate: invalid NewOverdraft arguments")
+	}
+	g := New(max + extra)
+	g.extra = extra
+	return g
+}
+
+// NewFIFO returns new Gate with provided capacity which lets goroutines
+// blocked on acquiring a slot with Lock and its variants do so in their
+// arrival order. Goroutines that acquire a free slot without waiting are not
+// ordered. Ordering adds the cost of an extra mutex to every acquisition, so
+// it is only worth it when starvation of waiters is a real issue. If capacity
+// is non-positive, NewFIFO would panic.
+func NewFIFO(max int) *Gate {
+	g := New(max)
+	g.fifo = new(fifo)
+	return g
+}
+
+// NewSpinning returns new Gate with provided capacity on which Lock and its
+// variants, when gate is full, retry acquiring a slot up to spins times,
+// yielding the processor with runtime.Gosched in between, before blocking.
+// When tasks are very short, slots are often freed within microseconds, and
+// spinning avoids the latency of parking and waking up the goroutine at the
+// cost of extra CPU use. Best spins value depends on the workload and should
+// be found by benchmarking. If capacity is non-positive, NewSpinning would
+// panic.
+func NewSpinning(max, spins int) *Gate {
+	g := New(max)
+	g.spins = spins
+	return g
+}
+
+// NewScaled returns new Gate with capacity of factor times
+// runtime.GOMAXPROCS(0), rounded to the nearest integer, but at least 1, e.g.
+// NewScaled(0.5) for half the available parallelism or NewScaled(2) for twice
+// of it. GOMAXPROCS is sampled once, later changes to it do not affect the
+// gate.
+func NewScaled(factor float64) *Gate {
+	n := math.Round(factor * float64(runtime.GOMAXPROCS(0)))
+	if !(n >= 1) {
+		n = 1
+	}
+	return New(int(n))
+}
+
+// NewWithSlowLog returns new Gate with provided capacity on which LockLabeled
+// calls log if it is blocked on acquiring a slot for longer than threshold.
+// If capacity is non-positive, NewWithSlowLog would panic.
+func NewWithSlowLog(max int, threshold time.Duration, log func(label string, waited time.Duration)) *Gate {
+	g := New(max)
+	g.slowAfter, g.slowLog = threshold, log
+	return g
+}
+
+// NewBounded returns new Gate with provided capacity on which LockOrReject
+// refuses to wait for a slot if maxWaiters goroutines are already waiting,
+// see Blocked. If capacity is non-positive, NewBounded would panic.
+func NewBounded(max, maxWaiters int) *Gate {
+	g := New(max)
+	g.maxWaiters = int64(maxWaiters)
+	return g
+}
+
+// MustNew is like NewGate, but panics if capacity is non-positive. It is
+// intended for initialization of package-level variables and other places
+// where capacity is a constant, making intent to panic on bad input explicit.
+func MustNew(max int) *Gate {
+	g, err := NewGate(max)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Clone returns new Gate with the same capacity and configuration as g, but
+// without held slots and with fresh statistics. Clone does not share any
+// state with g. Closed and retired state, OnResize subscriptions, OnRelease
+// and OnSaturate callbacks, Tee shadow, Ready channel, leak check, caller
+// tracking, owner check, deadlock warning, wait timeout, timeout scale and
+// strict mode settings are not copied.
+func (g *Gate) Clone() *Gate {
+	var c chan struct{}
+	if g.unl == nil {
+		old, _ := g.chans()
+		c = make(chan struct{}, cap(old))
+	}
+	n := newGate(c)
+	n.obs, n.holdObs = g.obs, g.holdObs
+	n.slowAfter, n.slowLog = g.slowAfter, g.slowLog
+	n.maxWaiters = g.maxWaiters
+	n.extra = g.extra
+	if g.fifo != nil {
+		n.fifo = new(fifo)
+	}
+	if g.bulk != nil {
+		n.bulk = new(bulkOrder)
+	}
+	if g.unl != nil {
+		n.unl = &counter{backend: g.unl.backend}
+	}
+	if g.rate != nil {
+		n.rate = &rate{interval: g.rate.interval}
+	}
+	if a := g.adapt; a != nil {
+		n.adapt = &adaptive{min: a.min, max: a.max, target: a.target, last: time.Now()}
+	}
+	n.parent = g.parent
+	n.fairAfter = g.fairAfter
+	n.spins = g.spins
+	n.softMax, n.onExceed = g.softMax, g.onExceed
+	return n
+}
+
+// NewGate returns new Gate with provided capacity. Unlike New, it returns an
+// error instead of panicking if capacity is non-positive, which makes it
+// suitable for capacities coming from user-supplied configuration.
+func NewGate(max int) (*Gate, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("%w %d", ErrCapacity, max)
+	}
+	return New(max), nil
+}
+
+// lockm locks g.m, giving up and returning false once done is closed.
+func (g *Gate) lockm(done <-chan struct{}) bool {
+	select {
+	case g.m <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// trylockm locks g.m if it can be done without blocking and reports whether
+// it succeeded.
+func (g *Gate) trylockm() bool {
+	select {
+	case g.m <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// unlockm unlocks g.m locked by lockm or trylockm.
+func (g *Gate) unlockm() { <-g.m }
+
+// chans returns channel holding acquired slots along with a channel which is
+// closed once the former is replaced by Resize. Goroutines blocked on sending
+// to the slots channel should also wait on swap and start over once it is
+// closed.
+func (g *Gate) chans() (c, swap chan struct{}) {
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+	return g.c, g.swap
+}
+
+// lock acquires a gate slot, giving up and returning false once done is
+// closed.
+func (g *Gate) lock(done <-chan struct{}) bool {
+	if g.retired.Load() {
+		return true
+	}
+	if !g.unpaused(done) {
+		return false
+	}
+	var start time.Time
+	if g.fifo != nil || g.starved.Load() > 0 || g.bulk.busy() || !g.spinSend() {
+		start = time.Now()
+		g.waiters.Add(1)
+		ok := g.queue(done)
+		g.waiters.Add(-1)
+		if !ok {
+			return false
+		}
+		if g.retired.Load() {
+			return true
+		}
+	}
+	if !g.lockParent(done) {
+		return false
+	}
+	return g.admit(done, start)
+}
+
+// queue waits for a gate slot in FIFO order if gate has it, giving up and
+// returning false once done is closed. Caller is responsible for accounting
+// itself in g.waiters and for calling admit once slot is acquired.
+func (g *Gate) queue(done <-chan struct{}) bool {
+	if g.preemptible.Load() > 0 {
+		g.contend()
+	}
+	if g.fifo != nil {
+		return g.fifo.lock(g, done)
+	}
+	if g.fairAfter > 0 {
+		return g.fairBlock(done)
+	}
+	if g.bulk != nil {
+		return g.bulkBlock(done)
+	}
+	return g.block(done)
+}
+
+// admit completes acquisition of a single slot, which was started at the
+// given time or acquired without waiting if start is zero. If gate is rate
+// limited, admit waits for its turn, releasing the slot and returning false
+// if done is closed. It then accounts the acquisition in gate statistics.
+func (g *Gate) admit(done <-chan struct{}, start time.Time) bool {
+	if g.rate != nil {
+		if start.IsZero() {
+			start = time.Now()
+		}
+		if !g.rate.wait(done) {
+			g.releaseParent(1)
+			g.release(1)
+			return false
+		}
+	}
+	var waited time.Duration
+	if !start.IsZero() {
+		waited = time.Since(start)
+	}
+	g.acquired(1, waited)
+	return true
+}
+
+// trySend acquires a gate slot if it can be done without blocking and
+// reports whether it succeeded. It does not update gate statistics.
+func (g *Gate) trySend() bool {
+	if g.unl != nil {
+		return g.unl.tryAcquire(1)
+	}
+	c, _ := g.chans()
+	select {
+	case c <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// spinSend is like trySend, but if gate was created with NewSpinning, it
+// retries failed attempts configured number of times, yielding the processor
+// in between.
+func (g *Gate) spinSend() bool {
+	if g.trySend() {
+		return true
+	}
+	for i := 0; i < g.spins; i++ {
+		runtime.Gosched()
+		if g.trySend() {
+			return true
+		}
+	}
+	return false
+}
+
+// block blocks until it acquires a gate slot, giving up and returning false
+// once done is closed. It does not update gate statistics.
+func (g *Gate) block(done <-chan struct{}) bool {
+	if g.unl != nil {
+		return g.unl.acquire(1, done)
+	}
+	for !g.retired.Load() {
+		c, swap := g.chans()
+		select {
+		case c <- struct{}{}:
+			return true
+		case <-swap:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+// acquired updates gate statistics once n slots were acquired after waiting
+// for the given time, calling WaitObserver if gate has one.
+func (g *Gate) acquired(n int, waited time.Duration) {
+	g.acquires.Add(uint64(n))
+	held := int64(g.Len())
+	for peak := g.peak.Load(); held > peak; peak = g.peak.Load() {
+		if g.peak.CompareAndSwap(peak, held) {
+			break
+		}
+	}
+	if g.onExceed != nil && held > int64(g.softMax) {
+		g.onExceed(int(held))
+	}
+	if fn := g.onSaturate.Load(); fn != nil && g.unl == nil && held >= int64(g.Cap()) &&
+		g.saturated.CompareAndSwap(false, true) {
+		(*fn)()
+	}
+	if waited > 0 {
+		g.waited.Add(int64(waited))
+	}
+	g.hist[histBucket(waited)].Add(1)
+	if shadow := g.tee.Load(); shadow != nil {
+		shadow.AcquireUpTo(n)
+	}
+	if t := g.track.Load(); t != nil {
+		t.acquired(n)
+	}
+	if o := g.owners.Load(); o != nil {
+		o.acquired(n)
+	}
+	if g.obs != nil {
+		g.obs(waited)
+	}
+}
 
 // Lock implements sync.Locker interface. Gate capacity determines number of
 // non-blocking Lock calls, when max number is reached, Lock would block until
 // some other goroutine calls Unlock. Lock is safe for concurrent use.
-func (g *Gate) Lock() { g.c <- struct{}{} }
+func (g *Gate) Lock() { g.lock(nil) }
+
+// LockLabeled locks gate like Lock does. If gate was created with
+// NewWithSlowLog and LockLabeled is blocked for longer than configured
+// threshold, it calls the log function once, passing it label and time spent
+// waiting so far, from a separate goroutine while LockLabeled continues to
+// wait. This helps to find goroutines stuck waiting on the gate.
+func (g *Gate) LockLabeled(label string) {
+	if g.slowLog == nil {
+		g.Lock()
+		return
+	}
+	if g.tryLock() {
+		return
+	}
+	start := time.Now()
+	t := time.AfterFunc(g.slowAfter, func() { g.slowLog(label, time.Since(start)) })
+	defer t.Stop()
+	g.Lock()
+}
+
+// LockOrReject locks gate like Lock does, but if gate is full and already has
+// maximum number of goroutines waiting for a slot, as set by NewBounded, it
+// returns ErrQueueFull without waiting. Caller should only call Unlock if
+// LockOrReject returned nil. Gates created by other constructors never
+// reject.
+func (g *Gate) LockOrReject() error {
+	if g.retired.Load() {
+		return nil
+	}
+	g.unpaused(nil)
+	if g.fifo == nil && g.starved.Load() == 0 && !g.bulk.busy() && g.trySend() {
+		g.lockParent(nil)
+		g.admit(nil, time.Time{})
+		return nil
+	}
+	start := time.Now()
+	n := g.waiters.Add(1)
+	if g.maxWaiters >= 0 && n > g.maxWaiters {
+		g.waiters.Add(-1)
+		g.rejections.Add(1)
+		return ErrQueueFull
+	}
+	g.queue(nil)
+	g.waiters.Add(-1)
+	if g.retired.Load() {
+		return nil
+	}
+	g.lockParent(nil)
+	g.admit(nil, start)
+	return nil
+}
+
+// LockQueued locks gate like Lock does and returns the number of goroutines
+// that were already waiting for a slot when it started waiting, or 0 if it
+// acquired a slot without waiting. The number is approximate: waiters are not
+// ordered unless gate was created with NewFIFO, so goroutines counted as
+// ahead of the caller may actually acquire slots after it.
+func (g *Gate) LockQueued() (depth int) {
+	if g.retired.Load() {
+		return 0
+	}
+	g.unpaused(nil)
+	if g.fifo == nil && g.starved.Load() == 0 && !g.bulk.busy() && g.spinSend() {
+		g.lockParent(nil)
+		g.admit(nil, time.Time{})
+		return 0
+	}
+	start := time.Now()
+	depth = int(g.waiters.Add(1) - 1)
+	g.queue(nil)
+	g.waiters.Add(-1)
+	if g.retired.Load() {
+		return depth
+	}
+	g.lockParent(nil)
+	g.admit(nil, start)
+	return depth
+}
+
+// LockIf locks gate like Lock does, then calls pred and, if it returns false,
+// unlocks gate and returns false. Since pred is called after the slot is
+// acquired, it sees the state at the moment of acquisition rather than the
+// possibly stale state from before waiting. Caller should only call Unlock if
+// LockIf returned true.
+func (g *Gate) LockIf(pred func() bool) bool {
+	g.Lock()
+	if !pred() {
+		g.Unlock()
+		return false
+	}
+	return true
+}
+
+// LockOrFallback locks g if it can be done without blocking, otherwise it
+// locks fallback, blocking if needed. It returns the gate that was locked,
+// which caller must later unlock: either g or fallback, never both.
+func (g *Gate) LockOrFallback(fallback *Gate) *Gate {
+	if g.TryLock() {
+		return g
+	}
+	fallback.Lock()
+	return fallback
+}
+
+// Pause pauses gate, so that all new acquisitions with Lock, Acquire and
+// their variants block until Resume is called, while non-blocking ones like
+// TryLock fail. Already held slots are not affected and can be released as
+// usual. Acquisitions that are already waiting for a slot when Pause is
+// called may still complete. LockFast and the channel returned by C ignore
+// pause. Pausing already paused gate is no-op.
+func (g *Gate) Pause() {
+	g.pmu.Lock()
+	defer g.pmu.Unlock()
+	if g.paused.Load() == nil {
+		ch := make(chan struct{})
+		g.paused.Store(&ch)
+	}
+}
+
+// Resume resumes gate paused by Pause, unblocking acquisitions waiting for
+// it. Resuming gate that is not paused is no-op.
+func (g *Gate) Resume() {
+	g.pmu.Lock()
+	defer g.pmu.Unlock()
+	if p := g.paused.Load(); p != nil {
+		g.paused.Store(nil)
+		close(*p)
+	}
+}
+
+// Barrier pauses gate like Pause does, waits until all held slots are
+// released, and calls fn while holding all gate slots, so that nothing else
+// holds the gate during fn call. It then resumes gate, unless gate was
+// already paused when Barrier was called. Lock calls made during the barrier
+// block rather than fail, and proceed once it lifts, in no particular order,
+// like calls waiting for a free slot do.
+func (g *Gate) Barrier(fn func()) {
+	if g.retired.Load() {
+		fn()
+		return
+	}
+	g.pmu.Lock()
+	wasPaused := g.paused.Load() != nil
+	if !wasPaused {
+		ch := make(chan struct{})
+		g.paused.Store(&ch)
+	}
+	g.pmu.Unlock()
+	if !wasPaused {
+		defer g.Resume()
+	}
+	if g.unl != nil {
+		g.unl.wait(nil)
+		fn()
+		return
+	}
+	g.lockm(nil)
+	defer g.unlockm()
+	var i int
+	defer func() {
+		for ; i > 0; i-- {
+			<-g.c
+		}
+		g.freed()
+	}()
+	// acquisitions waiting since before the pause may still take slots, but
+	// no new ones can join them
+	for ; i < cap(g.c); i++ {
+		g.c <- struct{}{}
+	}
+	fn()
+}
+
+// unpaused blocks while gate is paused, giving up and returning false once
+// done is closed.
+func (g *Gate) unpaused(done <-chan struct{}) bool {
+	for {
+		p := g.paused.Load()
+		if p == nil {
+			return true
+		}
+		select {
+		case <-*p:
+		case <-done:
+			return false
+		}
+	}
+}
+
+// LockOrClosed locks gate like Lock does, but returns ErrClosed once gate is
+// closed with Close, including when Close is called while LockOrClosed waits
+// for a slot. Caller should only call Unlock if LockOrClosed returned nil.
+func (g *Gate) LockOrClosed() error {
+	select {
+	case <-g.closed:
+		return ErrClosed
+	default:
+	}
+	if !g.lock(g.closed) {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close closes gate, so that all current and future LockOrClosed calls return
+// ErrClosed. Slots that are already held remain valid and should be unlocked
+// as usual. Other methods are not affected by Close. Close is idempotent and
+// always returns nil.
+func (g *Gate) Close() error {
+	g.closeOnce.Do(func() { close(g.closed) })
+	return nil
+}
+
+// CloseWait closes gate like Close does, then waits like WaitContext does
+// until all held slots are released, or until ctx is done, returning
+// ctx.Err(). Since no LockOrClosed call succeeds once CloseWait starts
+// waiting, this is a single step shutdown of code acquiring slots with
+// LockOrClosed.
+func (g *Gate) CloseWait(ctx context.Context) error {
+	g.Close()
+	return g.WaitContext(ctx)
+}
+
+// LockContext locks gate like Lock does, but gives up waiting once ctx is
+// done, returning ctx.Err(). If ctx is already done, LockContext returns its
+// error without acquiring a slot even if one is free. Caller should only call
+// Unlock if LockContext returned nil.
+func (g *Gate) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !g.lock(ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// LockTimeout locks gate like Lock does, but waits no longer than d for a
+// free slot. It reports whether gate was locked; caller should only call
+// Unlock if LockTimeout returned true. Non-positive d makes LockTimeout
+// behave like TryLock.
+func (g *Gate) LockTimeout(d time.Duration) bool {
+	if d <= 0 {
+		return g.TryLock()
+	}
+	done := make(chan struct{})
+	t := time.AfterFunc(d, func() { close(done) })
+	defer t.Stop()
+	return g.lock(done)
+}
+
+// LockRetry tries to lock gate with TryLock until it succeeds or ctx is
+// canceled, sleeping between attempts. Sleep starts at initial and doubles
+// after every failed attempt, up to max; each sleep is randomized to lie
+// between half and full of its nominal value, so that goroutines which failed
+// together do not retry together. It returns nil once gate is locked,
+// otherwise ctx.Err(); caller should only call Unlock if LockRetry returned
+// nil. Unlike LockContext, LockRetry does not keep a place among waiters, so
+// it can lose to Lock callers indefinitely.
+func (g *Gate) LockRetry(ctx context.Context, initial, max time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if initial <= 0 {
+		initial = time.Millisecond
+	}
+	if max < initial {
+		max = initial
+	}
+	t := time.NewTimer(0)
+	<-t.C
+	defer t.Stop()
+	for d := initial; ; {
+		if g.TryLock() {
+			return nil
+		}
+		t.Reset(d/2 + time.Duration(rand.Int63n(int64(d/2)+1)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if d *= 2; d > max {
+			d = max
+		}
+	}
+}
+
+// LockJittered locks gate like Lock does, then sleeps for a random duration
+// from 0 up to maxJitter before returning, so that goroutines which get slots
+// together do not hit downstream together. The jitter delays the caller, not
+// acquisition: the slot is held while it sleeps. Non-positive maxJitter makes
+// LockJittered behave like Lock.
+func (g *Gate) LockJittered(maxJitter time.Duration) {
+	g.Lock()
+	if maxJitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(maxJitter))))
+	}
+}
 
 // Unlock implements sync.Locker interface. Unlock is safe for concurrent use.
-func (g *Gate) Unlock() { <-g.c }
+func (g *Gate) Unlock() { g.Release(1) }
+
+// LockFast locks gate like Lock does, but bypasses all instrumentation: it
+// does not update statistics, nor calls WaitObserver, and ignores FIFO
+// ordering and rate limits. It is intended for hot paths where every atomic
+// operation counts. Gates locked with LockFast do not report accurate Stats,
+// Peak or Blocked values.
+func (g *Gate) LockFast() {
+	if !g.trySend() {
+		g.block(nil)
+	}
+}
+
+// UnlockFast unlocks gate like Unlock does, but does not update statistics,
+// see LockFast.
+func (g *Gate) UnlockFast() { g.release(1) }
+
+// UnlockSafe unlocks gate like Unlock does, but if gate holds no slots, it
+// returns ErrNotHeld instead of blocking forever. It helps to catch unmatched
+// Lock and Unlock calls; correct code can use cheaper Unlock.
+func (g *Gate) UnlockSafe() error {
+	if g.retired.Load() {
+		return nil
+	}
+	if o := g.owners.Load(); o != nil {
+		o.released(1)
+	}
+	if !g.tryRelease() {
+		return ErrNotHeld
+	}
+	g.releaseParent(1)
+	g.released(1)
+	return nil
+}
+
+// tryRelease releases a single slot if gate has any held and reports whether
+// it did. It does not update gate statistics.
+func (g *Gate) tryRelease() bool {
+	defer g.freed()
+	if g.unl != nil {
+		return g.unl.tryRelease()
+	}
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+	select {
+	case <-g.c:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryLock tries to lock gate without blocking and reports whether it
+// succeeded. Caller should only call Unlock if TryLock returned true. TryLock
+// is safe for concurrent use.
+func (g *Gate) TryLock() bool { return g.rejected(g.tryLock()) }
+
+// tryLock implements TryLock without accounting rejections.
+func (g *Gate) tryLock() bool { return g.tryLockWith(g.trySend) }
+
+// tryLockWith is like tryLock, but uses send to acquire a gate slot.
+func (g *Gate) tryLockWith(send func() bool) bool {
+	if g.retired.Load() {
+		return true
+	}
+	if g.paused.Load() != nil || g.bulk.busy() {
+		return false
+	}
+	if !send() {
+		return false
+	}
+	if g.parent != nil && !g.parent.TryLock() {
+		g.release(1)
+		return false
+	}
+	if g.rate != nil && !g.rate.tryReserve() {
+		g.releaseParent(1)
+		g.release(1)
+		return false
+	}
+	g.acquired(1, 0)
+	return true
+}
+
+// LockUpTo locks gate like TryLock does, but only if fewer than limit slots are
+// held, which allows to throttle harder than gate capacity, e.g. while
+// downstream is unhealthy, without resizing the gate. It reports whether gate
+// was locked. Held slots check and acquisition are done atomically, so
+// concurrent LockUpTo calls never overshoot limit together. LockUpTo does not
+// wait for a free slot, but it does wait for another bulk operation like Wait
+// to finish. Other acquisitions, like Lock, are only limited by gate capacity.
+func (g *Gate) LockUpTo(limit int) bool {
+	if g.retired.Load() {
+		return true
+	}
+	if g.unl != nil {
+		return g.rejected(g.tryLockWith(func() bool { return g.unl.tryAcquireBelow(limit) }))
+	}
+	g.lockm(nil)
+	defer g.unlockm()
+	if len(g.c) >= limit {
+		return g.rejected(false)
+	}
+	return g.TryLock()
+}
+
+// C returns a channel that can be used to lock gate as part of a select
+// statement: successful send of an empty struct to this channel acquires a
+// slot, and caller is then responsible for calling Unlock. Channel returned is
+// replaced on each Resize call, and old channel never accepts sends after that,
+// so C should be called anew for every select rather than cached.
+func (g *Gate) C() chan<- struct{} {
+	if g.unl != nil {
+		panic("gate: C called on unlimited gate")
+	}
+	c, _ := g.chans()
+	return c
+}
 
 // Add implements similar semantic to sync.WaitGroup.Add. If Add is called with
 // positive argument N, it essentially calls Lock N times; if N is negative, it
@@ -52,32 +1048,1562 @@ func (g *Gate) Unlock() { <-g.c }
 // Add would panic. Add is safe for concurrent use, but should be used with
 // care as deadlocks are possible.
 func (g *Gate) Add(n int) {
-	if c := cap(g.c); n > c || -n > c {
+	if g.retired.Load() {
+		return
+	}
+	if c := g.Cap(); g.unl == nil && (n > c || -n > c) {
 		panic("gate: out of range Add argument")
 	}
+	if n > 0 && g.unl != nil {
+		g.unpaused(nil)
+		g.unl.acquire(n, nil)
+		g.acquired(n, 0)
+		return
+	}
 	if n > 0 {
+		if w := g.addWarn.Load(); w != nil {
+			t := time.AfterFunc(w.after, func() { w.warn(n) })
+			defer t.Stop()
+		}
+		if g.bulk != nil {
+			g.bulk.enter()
+			defer g.bulk.leave()
+			g.Acquire(n)
+			return
+		}
 		for i := 0; i < n; i++ {
-			g.c <- struct{}{}
+			g.Lock()
 		}
 		return
 	}
-	for i := 0; i < (-n); i++ {
-		<-g.c
+	g.Release(-n)
+}
+
+// AddClamped is like Add, but instead of panicking it clamps n to the range
+// from minus gate capacity to gate capacity, and returns the value actually
+// applied. Clamping changes the meaning of the call and can hide bugs in code
+// computing n, so it should only be used where saturating is preferred over
+// crashing. For unlimited gate n is applied as is.
+func (g *Gate) AddClamped(n int) int {
+	if g.unl == nil {
+		if c := g.Cap(); n > c {
+			n = c
+		} else if n < -c {
+			n = -c
+		}
+	}
+	g.Add(n)
+	return n
+}
+
+// AddContext is like Add, but for positive n it gives up once ctx is done,
+// releasing slots it managed to acquire so far and returning ctx.Err().
+// Negative n releases slots exactly like Add does and never blocks.
+func (g *Gate) AddContext(ctx context.Context, n int) error {
+	if n <= 0 || (g.unl != nil && g.unl.backend == nil) {
+		g.Add(n)
+		return nil
+	}
+	if g.unl == nil && n > g.Cap() {
+		panic("gate: out of range AddContext argument")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if !g.lock(ctx.Done()) {
+			g.Release(i)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// AddDeadline is like AddContext, but gives up once deadline passes and
+// reports whether all n slots were acquired. On failure, slots acquired so far
+// are released.
+func (g *Gate) AddDeadline(n int, deadline time.Time) bool {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	return g.AddContext(ctx, n) == nil
+}
+
+// TryAdd is a non-blocking version of Add. For positive n it either acquires
+// all n slots without blocking and returns true, or acquires none and returns
+// false. For negative n it releases slots exactly like Add does and returns
+// true. Like Add, TryAdd panics if absolute value of n is greater than Gate
+// capacity.
+func (g *Gate) TryAdd(n int) bool {
+	if g.unl != nil && n > 0 && g.unl.backend != nil {
+		return g.rejected(g.tryAcquire(n))
+	}
+	if g.unl != nil {
+		g.Add(n)
+		return true
+	}
+	if c := g.Cap(); n > c || -n > c {
+		panic("gate: out of range TryAdd argument")
+	}
+	if n <= 0 {
+		g.Release(-n)
+		return true
+	}
+	return g.rejected(g.tryAcquire(n))
+}
+
+// WouldBlock reports whether Add(n) would block if called right now, i.e.
+// whether gate has fewer than n free slots or is paused. It always returns
+// false for non-positive n and for unlimited gate. Like Available, it is a
+// momentary hint which may be stale the instant it returns, use TryAdd to
+// acquire slots only if it can be done without blocking. Like Add,
+// WouldBlock panics if absolute value of n is greater than Gate capacity.
+func (g *Gate) WouldBlock(n int) bool {
+	if g.unl != nil {
+		return false
+	}
+	if c := g.Cap(); n > c || -n > c {
+		panic("gate: out of range WouldBlock argument")
+	}
+	if n <= 0 {
+		return false
+	}
+	return g.paused.Load() != nil || g.Available() < n
+}
+
+// TryLockN acquires n gate slots if it can be done without blocking and
+// reports whether it succeeded. It either acquires all n slots or none of
+// them, and concurrent TryLockN calls never make each other fail by holding
+// partially acquired slots. TryLockN returns false if n is negative or greater
+// than gate capacity. Acquired slots should be returned with Release(n).
+func (g *Gate) TryLockN(n int) bool {
+	if n < 0 || (g.unl == nil && n > g.Cap()) {
+		return false
+	}
+	return g.rejected(g.tryAcquire(n))
+}
+
+// AcquireUpTo acquires as many free gate slots as it can without blocking, but
+// no more than n, and returns the number of acquired slots, which should later
+// be returned with Release. Concurrent AcquireUpTo calls do not interleave, so
+// they never over-count the same free slots.
+func (g *Gate) AcquireUpTo(n int) int {
+	if n <= 0 || g.paused.Load() != nil {
+		return 0
+	}
+	if g.retired.Load() {
+		return n
+	}
+	if g.unl != nil {
+		got := g.unl.acquireUpTo(n)
+		if got > 0 {
+			g.acquired(got, 0)
+		}
+		return got
+	}
+	if !g.trylockm() {
+		return 0
+	}
+	defer g.unlockm()
+	var got int
+fill:
+	for ; got < n; got++ {
+		select {
+		case g.c <- struct{}{}:
+		default:
+			break fill
+		}
+	}
+	if got > 0 && g.parent != nil {
+		for p := g.parent.AcquireUpTo(got); got > p; got-- {
+			<-g.c
+		}
+		g.freed()
+	}
+	if got > 0 {
+		g.acquired(got, 0)
+	}
+	return got
+}
+
+// rejected accounts a rejected acquisition if ok is false, and returns ok.
+func (g *Gate) rejected(ok bool) bool {
+	if !ok {
+		g.rejections.Add(1)
+	}
+	return ok
+}
+
+// tryAcquire acquires n slots if it can be done without blocking and reports
+// whether it succeeded. Concurrent tryAcquire calls do not interleave, so they
+// can not make each other fail by holding partially acquired slots.
+func (g *Gate) tryAcquire(n int) bool {
+	if g.retired.Load() {
+		return true
 	}
+	if g.paused.Load() != nil {
+		return false
+	}
+	if g.unl != nil {
+		if !g.unl.tryAcquire(n) {
+			return false
+		}
+		g.acquired(n, 0)
+		return true
+	}
+	if !g.trylockm() {
+		return false
+	}
+	defer g.unlockm()
+	for i := 0; i < n; i++ {
+		select {
+		case g.c <- struct{}{}:
+		default:
+			for ; i > 0; i-- {
+				<-g.c
+			}
+			g.freed()
+			return false
+		}
+	}
+	if g.parent != nil && !g.parent.tryAcquire(n) {
+		for i := 0; i < n; i++ {
+			<-g.c
+		}
+		g.freed()
+		return false
+	}
+	g.acquired(n, 0)
+	return true
 }
 
 // Done semantic is the same as sync.WaitGroup.Done.
-func (g *Gate) Done() { <-g.c }
+func (g *Gate) Done() { g.Unlock() }
 
 // Wait blocks until nothing holds a single Gate lock. Its semantic is the same
-// as sync.WaitGroup.Wait.
+// as sync.WaitGroup.Wait. If wait timeout is set with SetWaitTimeout and Wait
+// can not complete in time, it panics.
 func (g *Gate) Wait() {
-	g.m.Lock()
-	defer g.m.Unlock()
-	for i := 0; i < cap(g.c); i++ {
+	d := time.Duration(g.waitTimeout.Load())
+	if d <= 0 {
+		g.wait(nil)
+		return
+	}
+	done := make(chan struct{})
+	t := time.AfterFunc(d, func() { close(done) })
+	defer t.Stop()
+	if !g.wait(done) {
+		panic(fmt.Sprintf("gate: Wait did not complete in %v, %d slots held", d, g.Len()))
+	}
+}
+
+// SetWaitTimeout makes Wait panic, reporting number of held slots, if it can
+// not complete within d, instead of blocking forever on slots that are never
+// released, e.g. because of Lock without matching Unlock. This is a debugging
+// aid for tests. Non-positive d disables the timeout, which is the default.
+func (g *Gate) SetWaitTimeout(d time.Duration) { g.waitTimeout.Store(int64(d)) }
+
+// WaitContext blocks like Wait does until nothing holds a single Gate lock and
+// returns nil, or until ctx is done, returning ctx.Err().
+func (g *Gate) WaitContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !g.wait(ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// WaitChan blocks like Wait does until nothing holds a single Gate lock and
+// returns true, or until stop is closed or receives a value, returning false.
+// It is WaitContext for code which signals cancellation with a channel. If
+// stop is already closed, WaitChan returns false without waiting.
+func (g *Gate) WaitChan(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	return g.wait(stop)
+}
+
+// WaitShared blocks like Wait does, but concurrent WaitShared calls share a
+// single wait: the first call waits for gate to become idle, while calls made
+// during that wait block until it completes, instead of each doing its own
+// wait in turn. Since such wait completes after all of them started, they
+// return once nothing held a single Gate lock, exactly like Wait.
+func (g *Gate) WaitShared() {
+	g.wmu.Lock()
+	if done := g.shared; done != nil {
+		g.wmu.Unlock()
+		<-done
+		return
+	}
+	done := make(chan struct{})
+	g.shared = done
+	g.wmu.Unlock()
+	// Wait may panic if wait timeout is set
+	defer func() {
+		g.wmu.Lock()
+		g.shared = nil
+		g.wmu.Unlock()
+		close(done)
+	}()
+	g.Wait()
+}
+
+// WaitActive blocks like Wait does, and reports whether any slots were held
+// when it started, i.e. whether it actually waited for some work to complete
+// rather than found gate already idle.
+func (g *Gate) WaitActive() bool {
+	if g.retired.Load() {
+		return false
+	}
+	if g.unl != nil {
+		active := g.unl.len() > 0
+		g.unl.wait(nil)
+		return active
+	}
+	g.lockm(nil)
+	defer g.unlockm()
+	active := len(g.c) > 0
+	g.fill(0, nil)
+	return active
+}
+
+// WaitFree blocks until gate has at least k free slots, without acquiring
+// any. Like Available, it reports a momentary state: by the time WaitFree
+// returns, other goroutines may have taken the free slots, so it is only a
+// way to wait for room for a batch before starting it, not a guarantee that
+// the batch is admitted without blocking. WaitFree returns immediately for
+// unlimited gate and for non-positive k, and panics if k is greater than gate
+// capacity, since it would block forever.
+func (g *Gate) WaitFree(k int) {
+	if k <= 0 || g.unl != nil {
+		return
+	}
+	if k > g.Cap() {
+		panic("gate: out of range WaitFree argument")
+	}
+	for {
+		free := g.freeChan()
+		if g.Available() >= k {
+			return
+		}
+		<-free
+	}
+}
+
+// freeChan returns a channel which is closed once gate slots are released or
+// gate is resized.
+func (g *Gate) freeChan() <-chan struct{} {
+	for {
+		if c := g.free.Load(); c != nil {
+			return *c
+		}
+		c := make(chan struct{})
+		if g.free.CompareAndSwap(nil, &c) {
+			return c
+		}
+	}
+}
+
+// freed wakes up WaitFree calls and fills the channel returned by Ready once
+// gate slots were released or gate was resized.
+func (g *Gate) freed() {
+	if c := g.free.Load(); c != nil && g.free.CompareAndSwap(c, nil) {
+		close(*c)
+	}
+	if r := g.ready.Load(); r != nil {
+		select {
+		case *r <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// DrainContext returns a context which is canceled once nothing holds a
+// single Gate lock, as observed by Wait. If gate is idle when DrainContext is
+// called, returned context is already canceled. Otherwise a goroutine waits
+// for gate to become idle and exits once it cancels the context; if gate never
+// becomes idle, that goroutine is never released.
+func (g *Gate) DrainContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	if g.Len() == 0 {
+		cancel()
+		return ctx
+	}
+	go func() {
+		defer cancel()
+		g.wait(nil)
+	}()
+	return ctx
+}
+
+// DrainProgress blocks until gate has no held slots, calling report with
+// the number of held slots every interval while it waits. It returns nil once
+// gate is idle, or ctx.Err() if ctx is done first. Unlike Wait, it does not
+// acquire any slots, so it does not delay other goroutines' acquisitions.
+func (g *Gate) DrainProgress(ctx context.Context, interval time.Duration, report func(remaining int)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		free := g.freeChan()
+		if g.Len() == 0 {
+			return nil
+		}
+		select {
+		case <-free:
+		case <-t.C:
+			if n := g.Len(); n > 0 {
+				report(n)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// IsIdle reports whether gate has no held slots. Like Len, it is a momentary
+// snapshot which may be stale the instant it returns.
+func (g *Gate) IsIdle() bool { return g.Len() == 0 }
+
+// TryWait is a non-blocking version of Wait: it reports whether nothing holds
+// a single Gate lock at the instant of the call. It returns false immediately
+// if gate is busy, or if another bulk operation like Wait, Drain or Resize is
+// in progress. Gate may be locked again the moment TryWait returns true, so
+// the result is only suitable for heuristics like skipping work while gate is
+// busy.
+func (g *Gate) TryWait() bool {
+	if g.retired.Load() {
+		return true
+	}
+	if g.unl != nil {
+		return g.unl.len() == 0
+	}
+	if !g.trylockm() {
+		return false
+	}
+	defer g.unlockm()
+	var i int
+	defer func() {
+		for ; i > 0; i-- {
+			<-g.c
+		}
+		g.freed()
+	}()
+	for ; i < cap(g.c); i++ {
+		select {
+		case g.c <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// wait blocks until gate is unlocked, giving up and returning false once done
+// is closed.
+func (g *Gate) wait(done <-chan struct{}) bool { return g.waitBelow(0, done) }
+
+// WaitBelow blocks until no more than n gate slots are held. WaitBelow(0) is
+// the same as Wait. Like Wait, it does not poll, but briefly acquires enough
+// slots to make sure others hold no more than n of them.
+func (g *Gate) WaitBelow(n int) { g.waitBelow(n, nil) }
+
+// waitBelow blocks until no more than n slots are held, giving up and
+// returning false once done is closed.
+func (g *Gate) waitBelow(n int, done <-chan struct{}) bool {
+	if n < 0 {
+		n = 0
+	}
+	if g.retired.Load() {
+		return true
+	}
+	if g.unl != nil {
+		return g.unl.waitBelow(n, done)
+	}
+	if !g.lockm(done) {
+		return false
+	}
+	defer g.unlockm()
+	return g.fill(n, done)
+}
+
+// fill acquires all but n gate slots and releases them back, which succeeds
+// once others hold no more than n slots. It gives up and returns false once
+// done is closed. Caller must hold g.m.
+func (g *Gate) fill(n int, done <-chan struct{}) bool {
+	var i int
+	defer func() {
+		for ; i > 0; i-- {
+			<-g.c
+		}
+		g.freed()
+	}()
+	for ; i < cap(g.c)-n; i++ {
+		select {
+		case g.c <- struct{}{}:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+// WaitIdle blocks until gate has no held slots continuously for the quiet
+// duration. Any acquisition during this window starts it over. WaitIdle
+// samples gate state periodically, every eighth of quiet but no more often
+// than once per millisecond, so it is a best-effort check: very short
+// acquisitions made by sending to the channel returned by C or with LockFast
+// may go unnoticed.
+func (g *Gate) WaitIdle(quiet time.Duration) {
+	interval := quiet / 8
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var since time.Time // when gate was first seen idle
+	seen := g.acquires.Load()
+	for {
+		if n := g.acquires.Load(); n != seen || g.Len() > 0 {
+			since, seen = time.Time{}, n
+		} else {
+			if since.IsZero() {
+				since = time.Now()
+			}
+			if time.Since(since) >= quiet {
+				return
+			}
+		}
+		<-t.C
+	}
+}
+
+// DrainTimeout waits for gate to have no held slots like Wait does, but no
+// longer than d. It returns ok=true if gate drained in time, otherwise it
+// returns ok=false and number of slots that were still held when time ran
+// out.
+func (g *Gate) DrainTimeout(d time.Duration) (remaining int, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if g.WaitContext(ctx) != nil {
+		return g.Len(), false
+	}
+	return 0, true
+}
+
+// Drain blocks until all slots held at the time of the call are released,
+// calling fn once per each released slot as soon as it is reclaimed. Unlike
+// Wait, Drain is intended as a teardown operation: ordering of Lock calls
+// racing with Drain is undefined, and such calls may or may not be accounted
+// as the held slots. For gates created with NewUnlimited, all fn calls happen
+// once gate drains completely.
+func (g *Gate) Drain(fn func()) {
+	if g.retired.Load() {
+		return
+	}
+	g.lockm(nil)
+	defer g.unlockm()
+	if g.unl != nil {
+		n := g.unl.len()
+		g.unl.wait(nil)
+		for ; n > 0; n-- {
+			fn()
+		}
+		return
+	}
+	var i int
+	defer func() {
+		for ; i > 0; i-- {
+			<-g.c
+		}
+		g.freed()
+	}()
+	// take all free slots first, so that every slot acquired afterwards is
+	// one released by its holder
+fill:
+	for ; i < cap(g.c); i++ {
+		select {
+		case g.c <- struct{}{}:
+		default:
+			break fill
+		}
+	}
+	for ; i < cap(g.c); i++ {
 		g.c <- struct{}{}
+		fn()
+	}
+}
+
+// Acquire acquires n gate slots at once, blocking until all of them are
+// available. Slots acquired by concurrent Acquire calls do not interleave, so
+// two Acquire calls never deadlock each other waiting for partially acquired
+// slots. Acquire returns an error if n is negative or exceeds gate capacity.
+// Acquired slots should be returned with Release(n).
+func (g *Gate) Acquire(n int) error { return g.AcquireContext(context.Background(), n) }
+
+// AcquireContext acquires n gate slots like Acquire does, but gives up once
+// ctx is done, releasing any slots it managed to acquire so far and returning
+// ctx.Err().
+func (g *Gate) AcquireContext(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if g.retired.Load() {
+		return nil
+	}
+	if !g.unpaused(ctx.Done()) || !g.lockm(ctx.Done()) {
+		return ctx.Err()
+	}
+	defer g.unlockm()
+	if n >= 0 && g.unl != nil {
+		if !g.unl.acquire(n, ctx.Done()) {
+			return ctx.Err()
+		}
+		g.acquired(n, 0)
+		return nil
+	}
+	if n < 0 || n > cap(g.c) {
+		return fmt.Errorf("%w: cannot acquire %d slots of %d", ErrCapacity, n, cap(g.c))
 	}
-	for i := 0; i < cap(g.c); i++ {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		select {
+		case g.c <- struct{}{}:
+		case <-ctx.Done():
+			for ; i > 0; i-- {
+				<-g.c
+			}
+			g.freed()
+			return ctx.Err()
+		}
+	}
+	if g.parent != nil {
+		if err := g.parent.AcquireContext(ctx, n); err != nil {
+			for i := 0; i < n; i++ {
+				<-g.c
+			}
+			g.freed()
+			return err
+		}
+	}
+	g.acquired(n, time.Since(start))
+	return nil
+}
+
+// LockExclusive acquires all gate slots, including overdraft ones, blocking
+// until it can, so that nothing else holds the gate until UnlockExclusive is
+// called. It is to Lock what write lock of sync.RWMutex is to its read lock.
+// Like Acquire, it acquires slots under the lock serializing bulk operations,
+// so concurrent LockExclusive calls do not deadlock each other by holding
+// parts of the gate. LockExclusive panics on unlimited gate.
+func (g *Gate) LockExclusive() {
+	if g.unl != nil {
+		panic("gate: LockExclusive called on unlimited gate")
+	}
+	for {
+		c, _ := g.chans()
+		// fails only if gate is resized in the meantime
+		if g.Acquire(cap(c)) == nil {
+			g.excl = cap(c)
+			return
+		}
+	}
+}
+
+// TryLockExclusive acquires all gate slots like LockExclusive does if it can
+// be done without blocking, and reports whether it succeeded. Caller should
+// only call UnlockExclusive if TryLockExclusive returned true.
+func (g *Gate) TryLockExclusive() bool {
+	if g.unl != nil {
+		panic("gate: TryLockExclusive called on unlimited gate")
+	}
+	c, _ := g.chans()
+	if !g.rejected(g.tryAcquire(cap(c))) {
+		return false
+	}
+	g.excl = cap(c)
+	return true
+}
+
+// UnlockExclusive releases all gate slots acquired by LockExclusive or
+// TryLockExclusive.
+func (g *Gate) UnlockExclusive() {
+	n := g.excl
+	g.excl = 0
+	g.Release(n)
+}
+
+// DowngradeExclusive releases all but one gate slots acquired by LockExclusive
+// or TryLockExclusive, so that caller continues as a regular holder of a
+// single slot, to be released with Unlock. Since the retained slot is never
+// released, there is no moment when caller holds nothing and another
+// goroutine could take the whole gate. DowngradeExclusive panics if gate is
+// not held exclusively.
+func (g *Gate) DowngradeExclusive() {
+	if g.excl == 0 {
+		panic("gate: DowngradeExclusive of gate not held exclusively")
+	}
+	n := g.excl
+	g.excl = 0
+	g.Release(n - 1)
+}
+
+// Acquire1 locks gate and returns a function unlocking it. Only the first
+// call of the returned function unlocks gate, extra calls are no-op, so it is
+// safe to use both as in
+//
+//	defer g.Acquire1()()
+//
+// and with explicit early release.
+func (g *Gate) Acquire1() (release func()) {
+	g.Lock()
+	var once sync.Once
+	return func() { once.Do(g.Unlock) }
+}
+
+// LockWG locks gate and adds one to wg counter, returning a function which
+// unlocks gate and calls wg.Done, so that both counters are always updated
+// together. Gate is unlocked before wg.Done is called, so once wg.Wait
+// returns, slots are already released. Like with Acquire1, only the first
+// call of the returned function has effect.
+func (g *Gate) LockWG(wg *sync.WaitGroup) (release func()) {
+	g.Lock()
+	wg.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.Unlock()
+			wg.Done()
+		})
+	}
+}
+
+// LockChan starts acquisition of a slot in a separate goroutine and returns a
+// channel which receives a function releasing the slot once it is acquired,
+// so that acquisition can be part of a select statement along with other
+// cases. Receiver must call the received function when done. Channel is
+// buffered, so the goroutine exits once slot is acquired, but if the channel
+// is abandoned, that goroutine lives until acquisition, and the slot is then
+// never released. Use LockContext from own goroutine where acquisition may
+// need to be abandoned.
+func (g *Gate) LockChan() <-chan func() {
+	ch := make(chan func(), 1)
+	go func() { ch <- g.Acquire1() }()
+	return ch
+}
+
+// LockWithLease locks gate like Acquire1 does, and calls onExpire in its own
+// goroutine if the returned function is not called within maxHold. Slot is
+// not released on expiration, since its holder may still be using it;
+// onExpire is only a signal of a possibly stuck holder, e.g. to log it. Calling
+// the returned function unlocks gate and stops the timer.
+func (g *Gate) LockWithLease(maxHold time.Duration, onExpire func()) (release func()) {
+	g.Lock()
+	t := time.AfterFunc(maxHold, onExpire)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.Stop()
+			g.Unlock()
+		})
+	}
+}
+
+// LockWithContext locks gate like LockContext does, and returns a child
+// context of parent together with a function which cancels it and unlocks
+// gate, tying the lifetime of the task to the held slot. Only the first call
+// of the returned function has effect, so it is safe to both defer it and
+// call it explicitly. If parent is done before a slot is acquired, gate is not
+// locked, parent is returned and release is a no-op; caller should check
+// ctx.Err() before starting the task.
+func (g *Gate) LockWithContext(parent context.Context) (ctx context.Context, release func()) {
+	if g.LockContext(parent) != nil {
+		return parent, func() {}
+	}
+	ctx, cancel := context.WithCancel(parent)
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			g.Unlock()
+		})
+	}
+}
+
+// Release releases n gate slots previously acquired with Acquire, Add or Lock
+// in a single call. Releasing more slots than currently held blocks, just
+// like extra Unlock calls do, but if n is negative or greater than gate
+// capacity, which is always a bug, Release panics.
+func (g *Gate) Release(n int) {
+	if n < 0 {
+		panic("gate: out of range Release argument")
+	}
+	if n > 1 && g.unl == nil {
+		if c, _ := g.chans(); n > cap(c) {
+			panic("gate: out of range Release argument")
+		}
+	}
+	if g.retired.Load() {
+		return
+	}
+	if o := g.owners.Load(); o != nil && n > 0 {
+		o.released(n)
+	}
+	g.releaseParent(n)
+	if g.strict.Load() && g.unl == nil {
+		g.releaseStrict(n)
+	} else {
+		g.release(n)
+	}
+	g.released(n)
+}
+
+// released updates gate statistics once n slots were released, and does the
+// rest of bookkeeping all release paths share: it rearms OnSaturate callback,
+// updates caller tracking, calls OnRelease callback and mirrors release on
+// Tee shadow.
+func (g *Gate) released(n int) {
+	if n <= 0 {
+		return
+	}
+	g.releases.Add(uint64(n))
+	if g.onSaturate.Load() != nil {
+		g.saturated.Store(false)
+	}
+	if t := g.track.Load(); t != nil {
+		t.released(n)
+	}
+	if fn := g.onRelease.Load(); fn != nil {
+		(*fn)(g.Available())
+	}
+	if shadow := g.tee.Load(); shadow != nil {
+		for i := 0; i < n; i++ {
+			if shadow.UnlockSafe() != nil {
+				break
+			}
+		}
+	}
+}
+
+// releaseStrict is like release, but panics instead of blocking if gate has
+// fewer than n held slots.
+func (g *Gate) releaseStrict(n int) {
+	defer g.freed()
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+	for i := 0; i < n; i++ {
+		select {
+		case <-g.c:
+		default:
+			panic("gate: unlock of unheld gate")
+		}
+	}
+}
+
+// SetStrict enables or disables strict mode, in which releasing more slots
+// than currently held with Unlock, Release and their variants panics instead
+// of blocking until somebody acquires a slot. This turns unmatched Unlock
+// calls from hangs into panics pointing at the offending call. Strict mode is
+// disabled by default. Unlimited gates always panic on such releases.
+func (g *Gate) SetStrict(enabled bool) { g.strict.Store(enabled) }
+
+// release releases n gate slots without updating gate statistics.
+func (g *Gate) release(n int) {
+	if g.retired.Load() {
+		return
+	}
+	defer g.freed()
+	if g.unl != nil {
+		g.unl.add(-n)
+		return
+	}
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+	for i := 0; i < n; i++ {
 		<-g.c
 	}
 }
+
+// Reset forcibly releases all held gate slots and returns how many of them
+// were released. It is only intended as a test or teardown helper to recover
+// gate leaked by a failed test, and must only be called when no goroutines
+// use the gate, otherwise gate accounting gets broken.
+func (g *Gate) Reset() int {
+	if g.retired.Load() {
+		return 0
+	}
+	g.lockm(nil)
+	defer g.unlockm()
+	if o := g.owners.Load(); o != nil {
+		o.reset()
+	}
+	if g.unl != nil {
+		n := g.unl.reset()
+		g.freed()
+		g.released(n)
+		return n
+	}
+	for n := 0; ; n++ {
+		select {
+		case <-g.c:
+		default:
+			g.freed()
+			g.released(n)
+			return n
+		}
+	}
+}
+
+// Resize changes gate capacity to max, keeping currently held slots. When
+// shrinking gate below the number of currently held slots, Resize blocks until
+// enough of them are released. Goroutines blocked on acquiring a slot are
+// moved over to the resized gate. Resize returns an error if max is
+// non-positive. It is safe for concurrent use, concurrent Resize, Acquire and
+// Wait calls are serialized.
+func (g *Gate) Resize(max int) error { return g.resize(max, nil) }
+
+// ResizeWait is like Resize, but once ctx is done before enough slots are
+// released to fit the new capacity, it gives up, leaving capacity unchanged,
+// and returns ctx.Err(). Once ResizeWait returns nil, resize has fully taken
+// effect: new capacity is in place and no more than max slots are held, not
+// counting overdraft. Growing gate never waits.
+func (g *Gate) ResizeWait(ctx context.Context, max int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := g.resize(max, ctx.Done()); err != errCanceled {
+		return err
+	}
+	return ctx.Err()
+}
+
+// errCanceled is returned by resize when done is closed.
+var errCanceled = errors.New("gate: resize canceled")
+
+// resize implements Resize, giving up and returning errCanceled once done is
+// closed before held slots fit the new capacity.
+func (g *Gate) resize(max int, done <-chan struct{}) error {
+	if max <= 0 {
+		return fmt.Errorf("%w %d", ErrCapacity, max)
+	}
+	if g.unl != nil {
+		return fmt.Errorf("%w: cannot resize unlimited gate", ErrCapacity)
+	}
+	if !g.lockm(done) {
+		return errCanceled
+	}
+	defer g.unlockm()
+	return g.resizeLocked(max, done)
+}
+
+// resizeLocked implements resize once gate is validated and g.m is held.
+func (g *Gate) resizeLocked(max int, done <-chan struct{}) error {
+	if g.retired.Load() {
+		return nil
+	}
+	old, size := g.c, max+g.extra
+	if size == cap(old) {
+		return nil
+	}
+	// when shrinking, take surplus slots first, so that no more than size
+	// slots are left held by others
+	surplus := 0
+	for ; surplus < cap(old)-size; surplus++ {
+		select {
+		case old <- struct{}{}:
+		case <-done:
+			for ; surplus > 0; surplus-- {
+				<-old
+			}
+			g.freed()
+			return errCanceled
+		}
+	}
+	g.rw.Lock()
+	// Unlock calls are blocked now, so filling old channel up makes it never
+	// accept new slots; everything not filled here is held by others and is
+	// migrated to the new channel
+	filled := 0
+fill:
+	for {
+		select {
+		case old <- struct{}{}:
+			filled++
+		default:
+			break fill
+		}
+	}
+	c := make(chan struct{}, size)
+	for i := 0; i < cap(old)-surplus-filled; i++ {
+		c <- struct{}{}
+	}
+	g.c = c
+	close(g.swap)
+	g.swap = make(chan struct{})
+	g.rw.Unlock()
+	g.notifyResize(max)
+	return nil
+}
+
+// Boost grows gate capacity by extra slots for duration d, then shrinks it
+// back by the same number of slots, blocking the shrink in a separate
+// goroutine until enough slots are released, like Resize does for the
+// caller. Boosts stack: Boost called while another boost is active grows
+// capacity further, and each boost reverts only its own extra slots once its
+// own d passes. Capacity changes made with Resize in the meantime are kept,
+// though shrink back never makes capacity less than 1. Boost panics if extra
+// is non-positive or gate is unlimited.
+func (g *Gate) Boost(extra int, d time.Duration) {
+	if extra <= 0 {
+		panic("gate: non-positive Boost argument")
+	}
+	if g.unl != nil {
+		panic("gate: Boost called on unlimited gate")
+	}
+	g.lockm(nil)
+	g.resizeLocked(cap(g.c)-g.extra+extra, nil)
+	g.unlockm()
+	time.AfterFunc(d, func() {
+		g.lockm(nil)
+		defer g.unlockm()
+		g.resizeLocked(max(cap(g.c)-g.extra-extra, 1), nil)
+	})
+}
+
+// Ready returns a channel which receives a value once gate may have a free
+// slot, allowing to wait for free capacity in a select statement:
+//
+//	select {
+//	case <-g.Ready():
+//		if g.TryLock() { ... }
+//	case <-ctx.Done():
+//	}
+//
+// Channel has a buffer of one value, which is filled when Ready is called
+// while gate has free slots, each time slots are released, whichever method
+// releases them, and each time gate capacity changes. Since the
+// buffered value is never lost, a wake-up can not be missed, but it can be
+// stale: by the time it is received, other goroutines may have taken the
+// free slots, so receiver should acquire a slot without blocking and wait on
+// the channel again if that fails. All Ready calls return the same channel,
+// and each buffered value wakes up only one receiver.
+func (g *Gate) Ready() <-chan struct{} {
+	g.readyOnce.Do(func() {
+		c := make(chan struct{}, 1)
+		g.ready.Store(&c)
+	})
+	c := *g.ready.Load()
+	if g.Available() > 0 {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	return c
+}
+
+// Tee makes every acquisition and release of g slots also acquire or release
+// the same number of shadow slots without blocking, so that shadow mirrors
+// usage of g, e.g. for tests to check shadow.Peak() after a run. Mirroring is
+// best-effort: shadow slots which can not be acquired without blocking, like
+// when shadow is smaller than g, are skipped, so shadow may undercount. Slots
+// acquired by sending to the channel returned by C or with LockFast are not
+// mirrored. Passing nil stops mirroring.
+func (g *Gate) Tee(shadow *Gate) { g.tee.Store(shadow) }
+
+// OnRelease sets fn to be called each time slots are released with Unlock,
+// Release and their variants, with the number of free slots right after the
+// release, as reported by Available. Unlike Ready, it is not coalesced, so it
+// allows to implement custom wake-up logic, e.g. to signal a producer exactly
+// when capacity opens up. fn is called from the releasing goroutine on the
+// hot path, so it should be fast. Passing nil disables the callback.
+func (g *Gate) OnRelease(fn func(available int)) {
+	if fn == nil {
+		g.onRelease.Store(nil)
+		return
+	}
+	g.onRelease.Store(&fn)
+}
+
+// OnSaturate sets fn to be called when acquisition of a slot leaves gate with
+// no free slots. It is edge-triggered: once fn is called, it is not called
+// again until some slots are released and gate becomes full again. fn is
+// called from the acquiring goroutine, so it should be fast. Passing nil
+// disables the callback. Unlimited gates never saturate.
+func (g *Gate) OnSaturate(fn func()) {
+	if fn == nil {
+		g.onSaturate.Store(nil)
+		return
+	}
+	g.saturated.Store(false)
+	g.onSaturate.Store(&fn)
+}
+
+// OnResize returns a channel which receives new gate capacity each time it is
+// changed by Resize. Every call returns a new channel, which stays registered
+// for the gate lifetime. Channel has a buffer for a single value; if consumer
+// has not received a previous value by the time of the next Resize, that
+// stale value is dropped in favor of the new one, so Resize never blocks on a
+// slow consumer, and consumer always sees the latest capacity.
+func (g *Gate) OnResize() <-chan int {
+	ch := make(chan int, 1)
+	g.subMu.Lock()
+	defer g.subMu.Unlock()
+	g.onResize = append(g.onResize, ch)
+	return ch
+}
+
+// notifyResize sends max to all channels returned by OnResize. Caller must
+// hold g.m, so that notifyResize calls do not interleave.
+func (g *Gate) notifyResize(max int) {
+	g.freed()
+	g.subMu.Lock()
+	defer g.subMu.Unlock()
+	for _, ch := range g.onResize {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- max
+	}
+}
+
+// Len returns number of currently held gate slots. Returned value is only a
+// snapshot which may already be stale by the time Len returns, so it should
+// only be used for monitoring, not for synchronization.
+func (g *Gate) Len() int {
+	if g.retired.Load() {
+		return 0
+	}
+	if g.unl != nil {
+		return g.unl.len()
+	}
+	c, _ := g.chans()
+	return len(c)
+}
+
+// Counter returns number of units added with Add and not yet marked with Done,
+// for code that uses gate as sync.WaitGroup. It is the same as Len and, like
+// Len, is a momentary snapshot which may be stale the instant it returns.
+func (g *Gate) Counter() int { return g.Len() }
+
+// Cap returns gate capacity, the maximum number of slots that can be held at
+// the same time. Capacity can be changed by Resize.
+func (g *Gate) Cap() int {
+	if g.unl != nil {
+		return -1
+	}
+	c, _ := g.chans()
+	return cap(c) - g.extra
+}
+
+// Available returns number of free gate slots, i.e. how many Lock calls would
+// currently succeed without blocking. Like Len, it is a momentary snapshot
+// which may be stale the instant it returns.
+func (g *Gate) Available() int {
+	if g.unl != nil {
+		return math.MaxInt
+	}
+	c, _ := g.chans()
+	if g.retired.Load() {
+		return cap(c) - g.extra
+	}
+	if n := cap(c) - g.extra - len(c); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Overdraft returns number of slots currently held above gate capacity, as
+// allowed by NewOverdraft. Like Len, it is a momentary snapshot.
+func (g *Gate) Overdraft() int {
+	if g.extra == 0 {
+		return 0
+	}
+	if n := g.Len() - g.Cap(); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// String returns gate state in a compact form like "gate(held=3/8)", where 3
+// is the number of currently held slots and 8 is gate capacity. Capacity of
+// gates created with NewUnlimited is shown as "inf".
+func (g *Gate) String() string {
+	b := make([]byte, 0, 32)
+	b = append(b, "gate(held="...)
+	b = strconv.AppendInt(b, int64(g.Len()), 10)
+	b = append(b, '/')
+	if g.unl != nil {
+		b = append(b, "inf"...)
+	} else {
+		b = strconv.AppendInt(b, int64(g.Cap()), 10)
+	}
+	return string(append(b, ')'))
+}
+
+// MarshalJSON implements json.Marshaler, encoding current gate state as
+// {"held":3,"cap":8,"available":5,"peak":6,"blocked":0}. Held, cap and
+// available are taken from the same momentary view of the gate, so they are
+// consistent with each other; for unlimited gate cap is -1. Like Len, the
+// encoded state may be stale the instant it returns.
+func (g *Gate) MarshalJSON() ([]byte, error) {
+	var held, capacity, avail int
+	if g.unl != nil {
+		held, capacity, avail = g.unl.len(), -1, math.MaxInt
+	} else {
+		c, _ := g.chans()
+		held, capacity = len(c), cap(c)-g.extra
+		if avail = capacity - held; avail < 0 {
+			avail = 0
+		}
+	}
+	b := make([]byte, 0, 80)
+	b = append(b, `{"held":`...)
+	b = strconv.AppendInt(b, int64(held), 10)
+	b = append(b, `,"cap":`...)
+	b = strconv.AppendInt(b, int64(capacity), 10)
+	b = append(b, `,"available":`...)
+	b = strconv.AppendInt(b, int64(avail), 10)
+	b = append(b, `,"peak":`...)
+	b = strconv.AppendInt(b, g.peak.Load(), 10)
+	b = append(b, `,"blocked":`...)
+	b = strconv.AppendInt(b, g.waiters.Load(), 10)
+	return append(b, '}'), nil
+}
+
+// Do locks gate, calls fn and unlocks gate once fn returns. Gate is unlocked
+// even if fn panics, panic is then propagated to the caller.
+func (g *Gate) Do(fn func()) {
+	g.Lock()
+	defer g.Unlock()
+	defer g.measure(time.Now())
+	fn()
+}
+
+// TryDo calls fn with gate locked and returns true if gate can be locked
+// without blocking, otherwise it returns false without calling fn. Gate is
+// unlocked even if fn panics.
+func (g *Gate) TryDo(fn func()) bool {
+	if !g.TryLock() {
+		return false
+	}
+	defer g.Unlock()
+	defer g.measure(time.Now())
+	fn()
+	return true
+}
+
+// DoErr is like Do, but returns error returned by fn.
+func (g *Gate) DoErr(fn func() error) error {
+	g.Lock()
+	defer g.Unlock()
+	defer g.measure(time.Now())
+	return fn()
+}
+
+// measure accounts duration of a task started at the given time, if gate
+// adapts its capacity to task durations.
+func (g *Gate) measure(start time.Time) {
+	if g.adapt != nil {
+		g.adapt.record(g, time.Since(start))
+	}
+}
+
+// DoContext is like Do, but acquires a slot with LockContext and passes ctx
+// to fn. If ctx is done before a slot is acquired, fn is not called and
+// DoContext returns ctx.Err(), otherwise it returns nil after fn returns.
+func (g *Gate) DoContext(ctx context.Context, fn func(context.Context)) error {
+	if err := g.LockContext(ctx); err != nil {
+		return err
+	}
+	defer g.Unlock()
+	fn(ctx)
+	return nil
+}
+
+// ScaleFunc computes timeout of a task started by DoAdaptive from base
+// timeout, number of free gate slots, counting the one task holds, and gate
+// capacity.
+type ScaleFunc func(base time.Duration, available, capacity int) time.Duration
+
+// DoAdaptive locks gate and calls fn with a context which is canceled after a
+// timeout scaled by current gate utilization, unlocking gate once fn returns.
+// By default the timeout is base*(Available()+1)/Cap() computed right after a
+// slot is acquired, so a task running alone on the gate gets base, and tasks
+// get tighter budgets as gate saturates. Use SetTimeoutScale to override the
+// formula. On unlimited gates the timeout is always base.
+func (g *Gate) DoAdaptive(base time.Duration, fn func(ctx context.Context)) {
+	g.Lock()
+	defer g.Unlock()
+	d := base
+	if capacity := g.Cap(); capacity > 0 {
+		scale := defaultScale
+		if f := g.scale.Load(); f != nil {
+			scale = *f
+		}
+		d = scale(base, g.Available()+1, capacity)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	defer g.measure(time.Now())
+	fn(ctx)
+}
+
+// SetTimeoutScale replaces the formula DoAdaptive uses to compute task
+// timeouts. Nil fn restores the default one.
+func (g *Gate) SetTimeoutScale(fn ScaleFunc) {
+	if fn == nil {
+		g.scale.Store(nil)
+		return
+	}
+	g.scale.Store(&fn)
+}
+
+func defaultScale(base time.Duration, available, capacity int) time.Duration {
+	return base * time.Duration(min(available, capacity)) / time.Duration(capacity)
+}
+
+// TimedDo is like Do, but also returns time spent waiting for a slot and time
+// spent running fn. If fn panics, gate is unlocked and panic is propagated.
+func (g *Gate) TimedDo(fn func()) (wait, run time.Duration) {
+	start := time.Now()
+	g.Lock()
+	defer g.Unlock()
+	wait = time.Since(start)
+	start = time.Now()
+	fn()
+	return wait, time.Since(start)
+}
+
+// Go locks gate and calls fn in a new goroutine, unlocking gate once fn
+// returns. If gate is full, Go blocks until it can be locked, so callers get a
+// natural backpressure. If fn panics, gate is unlocked before panic crashes
+// the program.
+func (g *Gate) Go(fn func()) {
+	g.Lock()
+	go func() {
+		defer g.Unlock()
+		fn()
+	}()
+}
+
+// Loop keeps running fn in new goroutines, each holding a gate slot acquired
+// with LockContext, so that gate capacity worth of fn calls are in flight
+// all the time, until ctx is done. It then stops starting new calls and
+// returns once all fn calls it started return, so that caller can measure
+// clean completion. This is a "sustain N concurrent operations" loop for load
+// generators and benchmarks.
+func (g *Gate) Loop(ctx context.Context, fn func()) {
+	var wg sync.WaitGroup
+	for g.LockContext(ctx) == nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer g.Unlock()
+			fn()
+		}()
+	}
+	wg.Wait()
+}
+
+// Blocked returns approximate number of goroutines currently waiting for a
+// gate slot. Only goroutines waiting in Lock and its variants are accounted,
+// and a goroutine is accounted from the moment it finds gate full until it
+// acquires a slot, so the value is an estimate suitable for trend signals.
+// Gates created with NewFIFO may briefly account goroutines that acquire a
+// slot without waiting.
+func (g *Gate) Blocked() int { return int(g.waiters.Load()) }
+
+// Peak returns maximum number of simultaneously held gate slots observed since
+// gate creation or the last ResetPeak call. Slots acquired by sending to the
+// channel returned by C are not accounted.
+func (g *Gate) Peak() int { return int(g.peak.Load()) }
+
+// histBuckets is the number of WaitHistogram buckets.
+const histBuckets = 8
+
+// WaitHistogram returns numbers of acquisitions by time spent waiting for a
+// slot, bucketed on a log scale: waits under 1µs, under 10µs, under 100µs,
+// under 1ms, under 10ms, under 100ms, under 1s, and of 1s or longer.
+// Acquisitions made without waiting fall into the first bucket. Acquisition
+// of multiple slots at once is counted once. Slots acquired with LockFast or
+// by sending to the channel returned by C are not accounted.
+func (g *Gate) WaitHistogram() []uint64 {
+	out := make([]uint64, histBuckets)
+	for i := range out {
+		out[i] = g.hist[i].Load()
+	}
+	return out
+}
+
+// histBucket returns index of WaitHistogram bucket for the given wait time.
+func histBucket(waited time.Duration) int {
+	i := 0
+	for limit := time.Microsecond; i < histBuckets-1 && waited >= limit; limit *= 10 {
+		i++
+	}
+	return i
+}
+
+// Recommend suggests gate capacity based on statistics collected since gate
+// creation, to be used as a tuning hint when sizing the gate for the next run
+// of a similar workload. The heuristic is simple: if at least one in ten
+// acquisitions waited for 1ms or longer, according to WaitHistogram, or was
+// rejected, see Rejections, gate was a bottleneck and Recommend suggests
+// capacity half again as large as the current one. Otherwise, if Peak stayed
+// below capacity, the extra slots were never used and Recommend suggests
+// Peak. In other cases, including when gate has no statistics yet, it
+// returns current capacity. For unlimited gates, like those created by
+// NewSoft, it suggests Peak. Recommend never returns less than 1.
+func (g *Gate) Recommend() int {
+	peak := max(g.Peak(), 1)
+	if g.unl != nil {
+		return peak
+	}
+	capacity := g.Cap()
+	var total, slow uint64
+	for i, n := range g.WaitHistogram() {
+		total += n
+		if i >= histBucket(time.Millisecond) {
+			slow += n
+		}
+	}
+	slow += g.Rejections()
+	total += g.Rejections()
+	switch {
+	case total == 0:
+		return capacity
+	case slow*10 >= total:
+		return capacity + max(capacity/2, 1)
+	case peak < capacity:
+		return peak
+	}
+	return capacity
+}
+
+// Rejections returns number of acquisitions which failed without waiting:
+// TryLock, TryLockN and TryAdd calls which returned false, and LockOrReject
+// calls which returned ErrQueueFull. Every failed attempt of LockRetry is
+// also counted. Together with Stats.Acquired it gives the share of load shed
+// by gate.
+func (g *Gate) Rejections() uint64 { return g.rejections.Load() }
+
+// WaitPeak blocks like Wait does and returns Peak, the maximum number of
+// simultaneously held slots since gate creation or the last ResetPeak call.
+// At the end of a batch run it tells whether gate capacity was fully used.
+func (g *Gate) WaitPeak() int {
+	g.Wait()
+	return g.Peak()
+}
+
+// ResetPeak resets value returned by Peak to the number of currently held
+// slots, allowing to measure peaks over consecutive intervals.
+func (g *Gate) ResetPeak() { g.peak.Store(int64(g.Len())) }
+
+// Stats is a snapshot of gate usage statistics.
+type Stats struct {
+	Held, Cap          int           // currently held slots and gate capacity
+	Acquired, Released uint64        // total number of acquired and released slots
+	Rejected           uint64        // total number of rejected acquisitions, see Rejections
+	TotalWait          time.Duration // total time spent waiting for slots
+}
+
+// Snapshot returns current gate statistics. Counters are updated
+// independently, so snapshot is only approximately consistent. Slots acquired
+// by sending to the channel returned by C are not accounted.
+func (g *Gate) Snapshot() Stats {
+	return Stats{
+		Held:      g.Len(),
+		Cap:       g.Cap(),
+		Acquired:  g.acquires.Load(),
+		Released:  g.releases.Load(),
+		Rejected:  g.rejections.Load(),
+		TotalWait: time.Duration(g.waited.Load()),
+	}
+}
+
+// GoRecover is like Go, but if fn panics, panic is recovered and onPanic is
+// called with the recovered value, instead of crashing the program. Gate is
+// unlocked before onPanic is called.
+func (g *Gate) GoRecover(fn func(), onPanic func(any)) {
+	g.Lock()
+	go func() {
+		defer func() {
+			r := recover()
+			g.Unlock()
+			if r != nil {
+				onPanic(r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// Semaphore is an alias of Gate for code that uses it as a counting
+// semaphore and prefers semaphore terminology.
+type Semaphore = Gate
+
+// P acquires a single slot, it is the same as Lock.
+func (g *Gate) P() { g.Lock() }
+
+// V releases a single slot, it is the same as Unlock.
+func (g *Gate) V() { g.Unlock() }
+
+// SetLeakCheck enables or disables leak detection for the gate. When enabled,
+// program panics if gate is garbage collected while still having held slots,
+// which indicates a Lock without matching Unlock. This is a debugging aid for
+// tests, it is disabled by default. Note that goroutines blocked on acquiring
+// slots keep gate reachable, so only gates leaked by all of their users are
+// detected.
+func (g *Gate) SetLeakCheck(enabled bool) {
+	if !enabled {
+		runtime.SetFinalizer(g, nil)
+		return
+	}
+	runtime.SetFinalizer(g, func(g *Gate) {
+		if n := g.Len(); n > 0 {
+			panic(fmt.Sprintf("gate: gate with %d held slots garbage collected", n))
+		}
+	})
+}
+
+// SetDeadlockWarn makes Add calls with positive argument n which block for
+// longer than d call warn(n) once, from a separate goroutine while Add
+// continues to wait. This is a debugging aid to find Add calls that deadlock,
+// e.g. because the same goroutine already holds slots other Add calls wait
+// for. Non-positive d or nil warn disables the warning, which is the default.
+func (g *Gate) SetDeadlockWarn(d time.Duration, warn func(n int)) {
+	if d <= 0 || warn == nil {
+		g.addWarn.Store(nil)
+		return
+	}
+	g.addWarn.Store(&addWarn{after: d, warn: warn})
+}
+
+// addWarn is the configuration set by SetDeadlockWarn.
+type addWarn struct {
+	after time.Duration
+	warn  func(n int)
+}
+
+// Observer is a read-only view of a gate, which can be passed to monitoring
+// code that should not be able to change gate state.
+type Observer interface {
+	Len() int
+	Cap() int
+	Available() int
+}
+
+// Observer returns read-only view of the gate. Returned value can not be
+// converted back to *Gate.
+func (g *Gate) Observer() Observer { return readOnly{g: g} }
+
+type readOnly struct{ g *Gate }
+
+func (r readOnly) Len() int       { return r.g.Len() }
+func (r readOnly) Cap() int       { return r.g.Cap() }
+func (r readOnly) Available() int { return r.g.Available() }
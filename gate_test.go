@@ -0,0 +1,1163 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireContextRollback(t *testing.T) {
+	g := New(5)
+	g.Add(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.AcquireContext(ctx, 5); err != context.DeadlineExceeded {
+		t.Fatalf("AcquireContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := g.Len(); n != 2 {
+		t.Fatalf("gate holds %d slots after canceled AcquireContext, want 2", n)
+	}
+}
+
+func TestWaitContextRollback(t *testing.T) {
+	g := New(3)
+	g.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after canceled WaitContext, want 1", n)
+	}
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after canceled WaitContext")
+	}
+}
+
+func TestAddContextRollback(t *testing.T) {
+	g := New(2)
+	g.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.AddContext(ctx, 2); err != ctx.Err() || err == nil {
+		t.Fatalf("AddContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after canceled AddContext, want 1", n)
+	}
+}
+
+func TestLockRetryCanceled(t *testing.T) {
+	g := New(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.LockRetry(ctx, time.Millisecond, time.Millisecond); err != context.Canceled {
+		t.Fatalf("LockRetry returned %v, want %v", err, context.Canceled)
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after LockRetry with canceled context, want 0", n)
+	}
+}
+
+func TestTryWait(t *testing.T) {
+	g := New(2)
+	if !g.IsIdle() || !g.TryWait() {
+		t.Fatal("idle gate reported as busy")
+	}
+	g.Lock()
+	if g.IsIdle() || g.TryWait() {
+		t.Fatal("busy gate reported as idle")
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after TryWait, want 1", n)
+	}
+}
+
+func TestRejections(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	g.TryLock()
+	g.TryLockN(1)
+	if n := g.Rejections(); n != 2 {
+		t.Fatalf("Rejections returned %d, want 2", n)
+	}
+	if s := g.Snapshot(); s.Rejected != 2 || s.Acquired != 1 {
+		t.Fatalf("Snapshot returned %+v, want 1 acquired and 2 rejected", s)
+	}
+}
+
+func TestLockOrFallback(t *testing.T) {
+	g, fallback := New(1), New(1)
+	if got := g.LockOrFallback(fallback); got != g {
+		t.Fatal("LockOrFallback did not lock free primary gate")
+	}
+	if got := g.LockOrFallback(fallback); got != fallback {
+		t.Fatal("LockOrFallback did not lock fallback gate")
+	}
+	if g.Len() != 1 || fallback.Len() != 1 {
+		t.Fatalf("gates hold %d and %d slots, want 1 and 1", g.Len(), fallback.Len())
+	}
+}
+
+func TestDeadlockWarn(t *testing.T) {
+	g := New(2)
+	warned := make(chan int, 1)
+	g.SetDeadlockWarn(5*time.Millisecond, func(n int) { warned <- n })
+	g.Add(2)
+	g.Add(-2)
+	select {
+	case n := <-warned:
+		t.Fatalf("warn(%d) called for non-blocking Add", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.Lock()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		g.Unlock()
+	}()
+	g.Add(2)
+	select {
+	case n := <-warned:
+		if n != 2 {
+			t.Fatalf("warn called with %d, want 2", n)
+		}
+	default:
+		t.Fatal("warn not called for blocked Add")
+	}
+}
+
+func TestSoft(t *testing.T) {
+	var exceeded []int
+	g := NewSoft(2, func(n int) { exceeded = append(exceeded, n) })
+	for i := 0; i < 4; i++ {
+		g.Lock()
+	}
+	if len(exceeded) != 2 || exceeded[0] != 3 || exceeded[1] != 4 {
+		t.Fatalf("onExceed called with %v, want [3 4]", exceeded)
+	}
+	g.Add(-4)
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots, want 0", n)
+	}
+}
+
+func TestReleaseOutOfRange(t *testing.T) {
+	g := New(2)
+	g.Add(2)
+	g.Release(2)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Release of more slots than capacity did not panic")
+		}
+	}()
+	g.Release(3)
+}
+
+func TestWaitActive(t *testing.T) {
+	g := New(2)
+	if g.WaitActive() {
+		t.Fatal("WaitActive reported work on idle gate")
+	}
+	g.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+	}()
+	if !g.WaitActive() {
+		t.Fatal("WaitActive reported no work on busy gate")
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after WaitActive, want 0", n)
+	}
+}
+
+func TestOnSaturate(t *testing.T) {
+	g := New(2)
+	var n int
+	g.OnSaturate(func() { n++ })
+	g.Lock()
+	g.Lock()
+	if n != 1 {
+		t.Fatalf("fn called %d times once gate is full, want 1", n)
+	}
+	g.Unlock()
+	g.Lock()
+	g.Unlock()
+	g.Unlock()
+	if n != 2 {
+		t.Fatalf("fn called %d times after gate saturated again, want 2", n)
+	}
+}
+
+func TestLockWithContext(t *testing.T) {
+	g := New(1)
+	ctx, release := g.LockWithContext(context.Background())
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots, want 1", n)
+	}
+	release()
+	release()
+	if ctx.Err() == nil {
+		t.Fatal("context not canceled by release")
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after release, want 0", n)
+	}
+}
+
+func TestWouldBlock(t *testing.T) {
+	g := New(3)
+	g.Add(2)
+	if g.WouldBlock(1) || !g.WouldBlock(2) || g.WouldBlock(-2) {
+		t.Fatal("WouldBlock does not match free slots")
+	}
+}
+
+func TestCloseWait(t *testing.T) {
+	g := New(2)
+	if err := g.LockOrClosed(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.CloseWait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("CloseWait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := g.LockOrClosed(); err != ErrClosed {
+		t.Fatalf("LockOrClosed returned %v after CloseWait, want %v", err, ErrClosed)
+	}
+	g.Unlock()
+	if err := g.CloseWait(context.Background()); err != nil {
+		t.Fatalf("CloseWait returned %v on idle gate", err)
+	}
+}
+
+func TestHistBucket(t *testing.T) {
+	for _, tc := range []struct {
+		waited time.Duration
+		want   int
+	}{
+		{0, 0},
+		{999 * time.Nanosecond, 0},
+		{time.Microsecond, 1},
+		{5 * time.Millisecond, 4},
+		{999 * time.Millisecond, 6},
+		{time.Second, 7},
+		{time.Hour, 7},
+	} {
+		if got := histBucket(tc.waited); got != tc.want {
+			t.Errorf("histBucket(%v) = %d, want %d", tc.waited, got, tc.want)
+		}
+	}
+	g := New(1)
+	g.Lock()
+	if h := g.WaitHistogram(); len(h) != 8 || h[0] != 1 {
+		t.Fatalf("WaitHistogram returned %v, want single acquisition in the first bucket", h)
+	}
+}
+
+func TestTryDo(t *testing.T) {
+	g := New(1)
+	var ran bool
+	if !g.TryDo(func() { ran = g.TryDo(func() {}) }) || ran {
+		t.Fatal("TryDo ran fn on full gate or did not run it on free one")
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after TryDo, want 0", n)
+	}
+}
+
+func TestWaitShared(t *testing.T) {
+	g := New(2)
+	g.Lock()
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			g.WaitShared()
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("WaitShared returned while gate is locked")
+	default:
+	}
+	g.Unlock()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
+
+func TestTee(t *testing.T) {
+	g, shadow := New(3), New(3)
+	g.Tee(shadow)
+	g.Add(2)
+	g.Lock()
+	if n := shadow.Len(); n != 3 {
+		t.Fatalf("shadow holds %d slots, want 3", n)
+	}
+	g.Release(3)
+	if n := shadow.Len(); n != 0 {
+		t.Fatalf("shadow holds %d slots after release, want 0", n)
+	}
+	if n := shadow.Peak(); n != 3 {
+		t.Fatalf("shadow peak is %d, want 3", n)
+	}
+}
+
+func TestReady(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	select {
+	case <-g.Ready():
+		t.Fatal("Ready channel readable while gate is full")
+	default:
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+	}()
+	select {
+	case <-g.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Ready channel not readable after Unlock")
+	}
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after Ready")
+	}
+}
+
+func TestReadyUnlockSafe(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	ready := g.Ready()
+	if err := g.UnlockSafe(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ready:
+	default:
+		t.Fatal("Ready channel not readable after UnlockSafe")
+	}
+}
+
+func TestReadyResize(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	ready := g.Ready()
+	if err := g.Resize(2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ready:
+	default:
+		t.Fatal("Ready channel not readable after Resize grew gate")
+	}
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after Ready")
+	}
+}
+
+func TestLockExclusive(t *testing.T) {
+	g := New(3)
+	g.Lock()
+	if g.TryLockExclusive() {
+		t.Fatal("TryLockExclusive succeeded on locked gate")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+	}()
+	g.LockExclusive()
+	if n := g.Len(); n != 3 {
+		t.Fatalf("gate holds %d slots after LockExclusive, want 3", n)
+	}
+	g.UnlockExclusive()
+	if !g.TryLockExclusive() {
+		t.Fatal("TryLockExclusive failed on idle gate")
+	}
+	g.UnlockExclusive()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after UnlockExclusive, want 0", n)
+	}
+}
+
+func TestErrCapacity(t *testing.T) {
+	if _, err := NewGate(0); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("NewGate returned %v, want error wrapping ErrCapacity", err)
+	}
+	if err := New(2).Acquire(3); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Acquire returned %v, want error wrapping ErrCapacity", err)
+	}
+	if err := NewUnlimited().Resize(2); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Resize returned %v, want error wrapping ErrCapacity", err)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, fn := range []func(){
+		func() { New(0) },
+		func() { New(-1) },
+		func() { NewChild(New(1), 0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("constructor with non-positive capacity did not panic")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestWaitFree(t *testing.T) {
+	g := New(3)
+	g.Add(3)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+	}()
+	g.WaitFree(2)
+	if n := g.Available(); n < 2 {
+		t.Fatalf("%d slots available after WaitFree(2)", n)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	g := New(2)
+	g.SetWaitTimeout(10 * time.Millisecond)
+	g.Wait()
+	g.Lock()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Wait on locked gate did not panic")
+		}
+		if n := g.Len(); n != 1 {
+			t.Fatalf("gate holds %d slots after Wait panic, want 1", n)
+		}
+	}()
+	g.Wait()
+}
+
+func TestDrainContext(t *testing.T) {
+	g := New(2)
+	if g.DrainContext().Err() == nil {
+		t.Fatal("DrainContext of idle gate is not canceled")
+	}
+	g.Lock()
+	ctx := g.DrainContext()
+	if ctx.Err() != nil {
+		t.Fatal("DrainContext of locked gate is canceled")
+	}
+	g.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("DrainContext not canceled once gate is idle")
+	}
+}
+
+func TestAddClamped(t *testing.T) {
+	g := New(2)
+	if n := g.AddClamped(5); n != 2 || g.Len() != 2 {
+		t.Fatalf("AddClamped(5) applied %d, gate holds %d slots; want 2 and 2", n, g.Len())
+	}
+	if n := g.AddClamped(-5); n != -2 || g.Len() != 0 {
+		t.Fatalf("AddClamped(-5) applied %d, gate holds %d slots; want -2 and 0", n, g.Len())
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	g := New(1)
+	prev := SetDefault(g)
+	defer SetDefault(prev)
+	Lock()
+	if n := g.Len(); n != 1 {
+		t.Fatalf("installed default gate holds %d slots after Lock, want 1", n)
+	}
+	Unlock()
+	if prev == g || prev.Cap() <= 0 {
+		t.Fatal("SetDefault did not return previous default gate")
+	}
+}
+
+func TestWaitPeak(t *testing.T) {
+	g := New(4)
+	g.Add(3)
+	g.Add(-3)
+	if n := g.WaitPeak(); n != 3 {
+		t.Fatalf("WaitPeak returned %d, want 3", n)
+	}
+}
+
+func TestLockChan(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	ch := g.LockChan()
+	select {
+	case <-ch:
+		t.Fatal("LockChan delivered slot of full gate")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Unlock()
+	release := <-ch
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots, want 1", n)
+	}
+	release()
+}
+
+func TestDrainProgress(t *testing.T) {
+	g := New(3)
+	g.Add(2)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		g.Add(-2)
+	}()
+	var reports int
+	err := g.DrainProgress(context.Background(), 5*time.Millisecond, func(n int) {
+		reports++
+		if n <= 0 || n > 2 {
+			t.Errorf("report called with %d, want 1 or 2", n)
+		}
+	})
+	if err != nil || reports == 0 {
+		t.Fatalf("DrainProgress returned %v after %d reports", err, reports)
+	}
+	g.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.DrainProgress(ctx, time.Millisecond, func(int) {}); err != context.DeadlineExceeded {
+		t.Fatalf("DrainProgress returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	g := New(2)
+	g.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Unlock()
+	}()
+	var held int
+	g.Barrier(func() {
+		held = g.Len()
+		if g.TryLock() {
+			t.Error("TryLock succeeded during barrier")
+		}
+	})
+	if held != 2 {
+		t.Fatalf("barrier held %d slots while running fn, want 2", held)
+	}
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after barrier")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	g := New(2)
+	g.SetStrict(true)
+	g.Lock()
+	g.Unlock()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Unlock of unheld strict gate did not panic")
+		}
+	}()
+	g.Unlock()
+}
+
+func TestLockUpTo(t *testing.T) {
+	g := New(4)
+	if !g.LockUpTo(2) || !g.LockUpTo(2) {
+		t.Fatal("LockUpTo failed below limit")
+	}
+	if g.LockUpTo(2) {
+		t.Fatal("LockUpTo succeeded at limit")
+	}
+	if !g.LockUpTo(3) {
+		t.Fatal("LockUpTo failed below raised limit")
+	}
+}
+
+func TestLockUpToConcurrent(t *testing.T) {
+	for _, g := range []*Gate{New(10), NewUnlimited()} {
+		var wg sync.WaitGroup
+		var locked atomic.Int64
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if g.LockUpTo(3) {
+					locked.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := locked.Load(); n != 3 || g.Len() != 3 {
+			t.Fatalf("concurrent LockUpTo(3) locked %d slots, gate holds %d, want 3", n, g.Len())
+		}
+	}
+}
+
+func TestLockQueued(t *testing.T) {
+	g := New(1)
+	if n := g.LockQueued(); n != 0 {
+		t.Fatalf("LockQueued on free gate returned %d, want 0", n)
+	}
+	go g.Lock()
+	for g.Blocked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	depth := make(chan int)
+	go func() { depth <- g.LockQueued() }()
+	time.Sleep(10 * time.Millisecond)
+	g.Unlock()
+	g.Unlock()
+	if n := <-depth; n != 1 {
+		t.Fatalf("LockQueued returned %d with one goroutine waiting, want 1", n)
+	}
+}
+
+func TestDoAdaptive(t *testing.T) {
+	g := New(4)
+	timeout := func() time.Duration {
+		var d time.Duration
+		g.DoAdaptive(time.Hour, func(ctx context.Context) {
+			deadline, _ := ctx.Deadline()
+			d = time.Until(deadline)
+		})
+		return d
+	}
+	if d := timeout(); d <= 59*time.Minute {
+		t.Fatalf("timeout on idle gate is %v, want about 1h", d)
+	}
+	g.Add(2)
+	if d := timeout(); d <= 29*time.Minute || d > 30*time.Minute {
+		t.Fatalf("timeout on half-full gate is %v, want about 30m", d)
+	}
+	g.SetTimeoutScale(func(base time.Duration, _, _ int) time.Duration { return base / 10 })
+	if d := timeout(); d > 6*time.Minute {
+		t.Fatalf("timeout with custom scale is %v, want about 6m", d)
+	}
+}
+
+func TestDowngradeExclusive(t *testing.T) {
+	g := New(3)
+	g.LockExclusive()
+	g.DowngradeExclusive()
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after DowngradeExclusive, want 1", n)
+	}
+	if g.TryLockExclusive() {
+		t.Fatal("TryLockExclusive succeeded with a downgraded holder")
+	}
+	g.Unlock()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after Unlock, want 0", n)
+	}
+}
+
+func TestResizeWait(t *testing.T) {
+	g := New(3)
+	g.Add(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.ResizeWait(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("ResizeWait returned %v, want context.DeadlineExceeded", err)
+	}
+	if g.Cap() != 3 || g.Len() != 3 {
+		t.Fatalf("gate has %d of %d slots held after canceled ResizeWait, want 3 of 3", g.Len(), g.Cap())
+	}
+	g.Add(-2)
+	if err := g.ResizeWait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if g.Cap() != 1 || g.Len() != 1 {
+		t.Fatalf("gate has %d of %d slots held after ResizeWait, want 1 of 1", g.Len(), g.Cap())
+	}
+}
+
+func TestLockJittered(t *testing.T) {
+	g := New(1)
+	start := time.Now()
+	g.LockJittered(20 * time.Millisecond)
+	if d := time.Since(start); d >= time.Second {
+		t.Fatalf("LockJittered took %v", d)
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after LockJittered, want 1", n)
+	}
+	g.Unlock()
+}
+
+func TestOnRelease(t *testing.T) {
+	g := New(3)
+	var got []int
+	g.OnRelease(func(available int) { got = append(got, available) })
+	g.Add(3)
+	g.Unlock()
+	g.Release(2)
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("OnRelease called with %v, want [1 3]", got)
+	}
+}
+
+func TestLoop(t *testing.T) {
+	g := New(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var calls, running atomic.Int32
+	g.Loop(ctx, func() {
+		calls.Add(1)
+		running.Add(1)
+		defer running.Add(-1)
+		time.Sleep(time.Millisecond)
+	})
+	if n := running.Load(); n != 0 {
+		t.Fatalf("%d calls still running after Loop returned", n)
+	}
+	if calls.Load() < 3 || g.Peak() > 3 {
+		t.Fatalf("Loop made %d calls with peak %d", calls.Load(), g.Peak())
+	}
+}
+
+func TestWaitChan(t *testing.T) {
+	g := New(3)
+	g.Lock()
+	stop := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+	if g.WaitChan(stop) {
+		t.Fatal("WaitChan returned true with a held slot")
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after stopped WaitChan, want 1", n)
+	}
+	g.Unlock()
+	if !g.WaitChan(make(chan struct{})) {
+		t.Fatal("WaitChan returned false on idle gate")
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	g := New(4)
+	if n := g.Recommend(); n != 4 {
+		t.Fatalf("Recommend on fresh gate returned %d, want 4", n)
+	}
+	g.Do(func() {})
+	if n := g.Recommend(); n != 1 {
+		t.Fatalf("Recommend on underused gate returned %d, want 1", n)
+	}
+	g.Add(4)
+	for i := 0; i < 10; i++ {
+		g.TryLock()
+	}
+	if n := g.Recommend(); n != 6 {
+		t.Fatalf("Recommend on overloaded gate returned %d, want 6", n)
+	}
+}
+
+func TestDefaultCap(t *testing.T) {
+	want := runtime.NumCPU()
+	if n, err := strconv.Atoi(os.Getenv("GATE_DEFAULT_MAX")); err == nil && n > 0 {
+		want = n
+	}
+	if n := DefaultCap(); n != want {
+		t.Fatalf("DefaultCap returned %d, want %d", n, want)
+	}
+	if n := Cap(); n != want {
+		t.Fatalf("default gate capacity is %d, want %d", n, want)
+	}
+}
+
+func TestLockWG(t *testing.T) {
+	g := New(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		release := g.LockWG(&wg)
+		go func() {
+			defer release()
+			defer release()
+		}()
+	}
+	wg.Wait()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after wg.Wait, want 0", n)
+	}
+}
+
+func TestNewScaled(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	if n := NewScaled(2).Cap(); n != 2*procs {
+		t.Fatalf("NewScaled(2) capacity is %d, want %d", n, 2*procs)
+	}
+	if n := NewScaled(0.001).Cap(); n != 1 {
+		t.Fatalf("NewScaled(0.001) capacity is %d, want 1", n)
+	}
+}
+
+func TestBoost(t *testing.T) {
+	g := New(2)
+	g.Boost(2, 20*time.Millisecond)
+	g.Boost(1, 40*time.Millisecond)
+	if n := g.Cap(); n != 5 {
+		t.Fatalf("boosted gate capacity is %d, want 5", n)
+	}
+	g.Add(4)
+	time.Sleep(30 * time.Millisecond)
+	if n := g.Cap(); n != 5 {
+		t.Fatalf("gate capacity is %d while shrink is blocked, want 5", n)
+	}
+	g.Add(-2)
+	for start := time.Now(); g.Cap() != 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("gate capacity is %d after boosts ended, want 2", g.Cap())
+		}
+	}
+	g.Add(-2)
+}
+
+func TestUnlockSafeBookkeeping(t *testing.T) {
+	g := New(2)
+	shadow := New(2)
+	g.Tee(shadow)
+	var released int
+	g.OnRelease(func(int) { released++ })
+	g.Lock()
+	if err := g.UnlockSafe(); err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 || shadow.Len() != 0 || g.Snapshot().Released != 1 {
+		t.Fatalf("UnlockSafe: OnRelease called %d times, shadow holds %d, %d released, want 1, 0, 1",
+			released, shadow.Len(), g.Snapshot().Released)
+	}
+}
+
+func TestOnSaturateReset(t *testing.T) {
+	g := New(2)
+	var n int
+	g.OnSaturate(func() { n++ })
+	g.Add(2)
+	g.Reset()
+	g.Add(2)
+	g.UnlockSafe()
+	g.Lock()
+	if n != 3 {
+		t.Fatalf("OnSaturate called %d times after 3 saturations, want 3", n)
+	}
+}
+
+func TestWaitSharedTimeout(t *testing.T) {
+	g := New(1)
+	g.SetWaitTimeout(10 * time.Millisecond)
+	g.Lock()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("WaitShared did not panic on wait timeout")
+			}
+		}()
+		g.WaitShared()
+	}()
+	g.Unlock()
+	done := make(chan struct{})
+	go func() {
+		g.WaitShared()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WaitShared hangs on idle gate after a timed out WaitShared")
+	}
+}
+
+func TestResize(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	locked := make(chan struct{})
+	go func() {
+		g.Lock()
+		close(locked)
+	}()
+	for g.Blocked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := g.Resize(2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("goroutine blocked before Resize was not moved over to resized gate")
+	}
+	if n, c := g.Len(), g.Cap(); n != 2 || c != 2 {
+		t.Fatalf("resized gate holds %d/%d slots, want 2/2", n, c)
+	}
+
+	resized := make(chan struct{})
+	go func() {
+		g.Resize(1)
+		close(resized)
+	}()
+	select {
+	case <-resized:
+		t.Fatal("shrinking Resize returned while too many slots were held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Unlock()
+	<-resized
+	if n, c := g.Len(), g.Cap(); n != 1 || c != 1 {
+		t.Fatalf("shrunk gate holds %d/%d slots, want 1/1", n, c)
+	}
+	if g.TryLock() {
+		t.Fatal("TryLock succeeded on full shrunk gate")
+	}
+	if err := g.Resize(0); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Resize(0) returned %v, want error wrapping ErrCapacity", err)
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	g := New(4)
+	if err := g.Acquire(3); err != nil {
+		t.Fatal(err)
+	}
+	if n := g.Len(); n != 3 {
+		t.Fatalf("gate holds %d slots after Acquire(3), want 3", n)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		g.Acquire(2)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Acquire(2) returned with only one slot free")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Release(3)
+	<-acquired
+	if n := g.Len(); n != 2 {
+		t.Fatalf("gate holds %d slots, want 2", n)
+	}
+	g.Release(2)
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after Release, want 0", n)
+	}
+	if err := g.Acquire(-1); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Acquire(-1) returned %v, want error wrapping ErrCapacity", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, g := range []*Gate{New(3), NewUnlimited()} {
+		g.Lock()
+		g.Lock()
+		if n := g.Reset(); n != 2 {
+			t.Fatalf("Reset released %d slots, want 2", n)
+		}
+		if n := g.Len(); n != 0 {
+			t.Fatalf("gate holds %d slots after Reset, want 0", n)
+		}
+		if n := g.Reset(); n != 0 {
+			t.Fatalf("Reset of idle gate released %d slots, want 0", n)
+		}
+		g.Wait()
+		if !g.TryLock() {
+			t.Fatal("TryLock failed after Reset")
+		}
+	}
+}
+
+func TestLockOrReject(t *testing.T) {
+	g := NewBounded(1, 1)
+	if err := g.LockOrReject(); err != nil {
+		t.Fatalf("LockOrReject on free gate returned %v", err)
+	}
+	locked := make(chan error)
+	go func() { locked <- g.LockOrReject() }()
+	for g.Blocked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := g.LockOrReject(); err != ErrQueueFull {
+		t.Fatalf("LockOrReject with full wait queue returned %v, want %v", err, ErrQueueFull)
+	}
+	g.Unlock()
+	if err := <-locked; err != nil {
+		t.Fatalf("waiting LockOrReject returned %v", err)
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots, want 1", n)
+	}
+	if n := g.Rejections(); n != 1 {
+		t.Fatalf("gate reports %d rejections, want 1", n)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	g := NewFIFO(1)
+	g.Lock()
+	order := make(chan int)
+	for i := 0; i < 5; i++ {
+		i := i
+		go func() {
+			g.Lock()
+			order <- i
+		}()
+		for g.Blocked() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		g.Unlock()
+		if n := <-order; n != i {
+			t.Fatalf("waiter %d acquired slot %d-th, want FIFO order", n, i)
+		}
+	}
+	g.Unlock()
+}
+
+func TestDrain(t *testing.T) {
+	g := New(3)
+	g.Lock()
+	g.Lock()
+	var reclaimed atomic.Int64
+	drained := make(chan struct{})
+	go func() {
+		g.Drain(func() { reclaimed.Add(1) })
+		close(drained)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if n := reclaimed.Load(); n != 0 {
+		t.Fatalf("Drain reclaimed %d slots before any release", n)
+	}
+	g.Unlock()
+	for reclaimed.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a slot was still held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Unlock()
+	<-drained
+	if n := reclaimed.Load(); n != 2 {
+		t.Fatalf("Drain reclaimed %d slots, want 2", n)
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after Drain, want 0", n)
+	}
+}
+
+func TestOverdraft(t *testing.T) {
+	g := NewOverdraft(2, 1)
+	for i := 0; i < 3; i++ {
+		if !g.TryLock() {
+			t.Fatalf("TryLock %d failed within overdraft", i+1)
+		}
+	}
+	if g.TryLock() {
+		t.Fatal("TryLock succeeded above overdraft")
+	}
+	if n, c, a, o := g.Len(), g.Cap(), g.Available(), g.Overdraft(); n != 3 || c != 2 || a != 0 || o != 1 {
+		t.Fatalf("Len, Cap, Available, Overdraft are %d, %d, %d, %d; want 3, 2, 0, 1", n, c, a, o)
+	}
+	g.Unlock()
+	if a, o := g.Available(), g.Overdraft(); a != 0 || o != 0 {
+		t.Fatalf("Available, Overdraft are %d, %d; want 0, 0", a, o)
+	}
+	g.Unlock()
+	g.Unlock()
+	g.Wait()
+}
+
+func TestClone(t *testing.T) {
+	g := NewOverdraft(2, 1)
+	g.Lock()
+	g.Lock()
+	c := g.Clone()
+	if n, cp, p := c.Len(), c.Cap(), c.Peak(); n != 0 || cp != 2 || p != 0 {
+		t.Fatalf("clone Len, Cap, Peak are %d, %d, %d; want 0, 2, 0", n, cp, p)
+	}
+	for i := 0; i < 3; i++ {
+		if !c.TryLock() {
+			t.Fatalf("TryLock %d on clone failed, overdraft not copied", i+1)
+		}
+	}
+	if n := g.Len(); n != 2 {
+		t.Fatalf("original gate holds %d slots after locking clone, want 2", n)
+	}
+	if u := NewUnlimited().Clone(); u.Cap() != -1 {
+		t.Fatalf("clone of unlimited gate has capacity %d, want -1", u.Cap())
+	}
+}
+
+func TestPause(t *testing.T) {
+	g := New(2)
+	g.Lock()
+	g.Pause()
+	g.Pause()
+	if g.TryLock() {
+		t.Fatal("TryLock succeeded on paused gate")
+	}
+	locked := make(chan struct{})
+	go func() {
+		g.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Lock returned on paused gate")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Unlock()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("paused gate holds %d slots after Unlock, want 0", n)
+	}
+	g.Resume()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Lock blocked after Resume")
+	}
+	g.Resume()
+	if !g.TryLock() {
+		t.Fatal("TryLock failed on resumed gate")
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	g := New(4)
+	g.Lock()
+	g.Lock()
+	g.Unlock()
+	b, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Held, Cap, Available, Peak, Blocked int }
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("MarshalJSON produced invalid JSON %s: %v", b, err)
+	}
+	if got.Held != 1 || got.Cap != 4 || got.Available != 3 || got.Peak != 2 || got.Blocked != 0 {
+		t.Fatalf("MarshalJSON produced %s", b)
+	}
+	b, _ = json.Marshal(NewUnlimited())
+	if err := json.Unmarshal(b, &got); err != nil || got.Cap != -1 {
+		t.Fatalf("MarshalJSON of unlimited gate produced %s", b)
+	}
+}
@@ -0,0 +1,25 @@
+// Package gatetest provides helpers for testing code limited by gate.Gate.
+package gatetest
+
+import (
+	"testing"
+
+	"github.com/artyom/gate"
+)
+
+// AssertMaxConcurrency resets peak of g, see gate.Gate.ResetPeak, and
+// registers a cleanup function which fails the test if more than max slots
+// of g were held at once by the time the test completes. This allows to test
+// that code respects concurrency limit directly instead of inferring it from
+// timing. Since peak is tracked by g itself, nothing else should call
+// ResetPeak on it during the test, and slots acquired by sending to the
+// channel returned by C or with LockFast are not accounted.
+func AssertMaxConcurrency(t testing.TB, g *gate.Gate, max int) {
+	t.Helper()
+	g.ResetPeak()
+	t.Cleanup(func() {
+		if peak := g.Peak(); peak > max {
+			t.Errorf("gate had %d slots held at once, want no more than %d", peak, max)
+		}
+	})
+}
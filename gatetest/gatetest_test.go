@@ -0,0 +1,49 @@
+package gatetest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/artyom/gate"
+)
+
+func TestAssertMaxConcurrency(t *testing.T) {
+	g := gate.New(4)
+	t.Run("within", func(t *testing.T) {
+		AssertMaxConcurrency(t, g, 4)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				g.Do(func() {})
+			}()
+		}
+		wg.Wait()
+	})
+	ft := &fakeTB{TB: t}
+	func() {
+		defer ft.cleanup()
+		AssertMaxConcurrency(ft, g, 1)
+		g.Add(2)
+		g.Add(-2)
+	}()
+	if !ft.failed {
+		t.Fatal("AssertMaxConcurrency did not fail on exceeded limit")
+	}
+}
+
+type fakeTB struct {
+	testing.TB
+	failed   bool
+	cleanups []func()
+}
+
+func (t *fakeTB) Helper()               {}
+func (t *fakeTB) Cleanup(fn func())     { t.cleanups = append(t.cleanups, fn) }
+func (t *fakeTB) Errorf(string, ...any) { t.failed = true }
+func (t *fakeTB) cleanup() {
+	for _, fn := range t.cleanups {
+		fn()
+	}
+}
@@ -0,0 +1,53 @@
+package gate
+
+import (
+	"context"
+	"sync"
+)
+
+// A Group is a collection of goroutines working on subtasks of a common task,
+// similar to golang.org/x/sync/errgroup.Group, but with number of concurrently
+// running goroutines limited by a Gate.
+type Group struct {
+	g    *Gate
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+}
+
+// NewGroup returns new Group which runs no more than max goroutines at the
+// same time. If max is non-positive, NewGroup would panic.
+func NewGroup(max int) *Group { return &Group{g: New(max)} }
+
+// Go calls fn in a new goroutine, blocking until group has a free slot for it.
+// The first non-nil error returned by fn is returned by Wait.
+func (gr *Group) Go(fn func() error) {
+	gr.g.Lock()
+	gr.wg.Add(1)
+	go func() {
+		defer gr.wg.Done()
+		defer gr.g.Unlock()
+		if err := fn(); err != nil {
+			gr.once.Do(func() { gr.err = err })
+		}
+	}()
+}
+
+// Wait blocks until all goroutines started with Go return, then returns the
+// first non-nil error (if any) returned by them.
+func (gr *Group) Wait() error {
+	gr.wg.Wait()
+	return gr.err
+}
+
+// WaitContext is like Wait, but gives up waiting once ctx is done, returning
+// ctx.Err(). Returning on ctx does not stop goroutines started with Go, they
+// continue to run in the background; callers should propagate their own
+// cancellation to the functions passed to Go.
+func (gr *Group) WaitContext(ctx context.Context) error {
+	// every running goroutine holds a gate slot
+	if err := gr.g.WaitContext(ctx); err != nil {
+		return err
+	}
+	return gr.err
+}
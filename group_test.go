@@ -0,0 +1,37 @@
+package gate
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	gr := NewGroup(2)
+	var running, peak atomic.Int64
+	errFail := errors.New("fail")
+	for i := 0; i < 10; i++ {
+		i := i
+		gr.Go(func() error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			if i == 3 {
+				return errFail
+			}
+			return nil
+		})
+	}
+	if err := gr.Wait(); err != errFail {
+		t.Fatalf("Wait returned %v, want %v", err, errFail)
+	}
+	if n := peak.Load(); n > 2 {
+		t.Fatalf("group ran %d goroutines concurrently, want no more than 2", n)
+	}
+	if n := running.Load(); n != 0 {
+		t.Fatalf("%d goroutines still running after Wait", n)
+	}
+}
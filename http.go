@@ -0,0 +1,34 @@
+package gate
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// LimitHandler returns middleware which serves each request with a slot of g
+// held. If g has no free slot, up to queue requests wait for one, giving up
+// once request context is done; requests which can neither get a slot nor
+// wait for it are rejected with http.StatusServiceUnavailable, as are those
+// whose context is done while waiting.
+func LimitHandler(g *Gate, queue int) func(http.Handler) http.Handler {
+	var waiting atomic.Int64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !g.TryLock() {
+				if waiting.Add(1) > int64(queue) {
+					waiting.Add(-1)
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				err := g.LockContext(r.Context())
+				waiting.Add(-1)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+			}
+			defer g.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
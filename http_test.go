@@ -0,0 +1,23 @@
+package gate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimitHandler(t *testing.T) {
+	g := New(1)
+	h := LimitHandler(g, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request on free gate got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	g.Lock()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request on full gate got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,24 @@
+package gate
+
+// Limiter is a concurrency limiter, implemented by Gate. It matches
+// sync.Locker, so code accepting Limiter can be given either a Gate or a
+// NopLimiter to disable limiting, e.g. in benchmarks.
+type Limiter interface {
+	Lock()
+	Unlock()
+}
+
+var _ Limiter = (*Gate)(nil)
+
+// NopLimiter is a Limiter which does not limit anything: its Lock and Unlock
+// methods do nothing.
+type NopLimiter struct{}
+
+// Lock does nothing.
+func (NopLimiter) Lock() {}
+
+// Unlock does nothing.
+func (NopLimiter) Unlock() {}
+
+// Nop returns a Limiter which does not limit anything.
+func Nop() Limiter { return NopLimiter{} }
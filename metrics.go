@@ -0,0 +1,46 @@
+package gate
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes gate state to w in Prometheus text exposition format,
+// labeling each metric with the given gate name:
+//
+//	gate_held{name="x"} 3
+//	gate_capacity{name="x"} 8
+//	gate_waiting{name="x"} 0
+//	gate_peak{name="x"} 5
+//	gate_acquired_total{name="x"} 120
+//	gate_released_total{name="x"} 117
+//	gate_rejected_total{name="x"} 2
+//	gate_wait_seconds_total{name="x"} 0.25
+//
+// Capacity of unlimited gate is reported as -1. Values are read like
+// Snapshot does, so they are only approximately consistent. WriteMetrics
+// returns the first error from writing to w.
+func (g *Gate) WriteMetrics(w io.Writer, name string) error {
+	s := g.Snapshot()
+	label := `{name="` + labelEscaper.Replace(name) + `"} `
+	bw := bufio.NewWriter(w)
+	line := func(metric, value string) {
+		bw.WriteString(metric)
+		bw.WriteString(label)
+		bw.WriteString(value)
+		bw.WriteByte('\n')
+	}
+	line("gate_held", strconv.Itoa(s.Held))
+	line("gate_capacity", strconv.Itoa(s.Cap))
+	line("gate_waiting", strconv.Itoa(g.Blocked()))
+	line("gate_peak", strconv.Itoa(g.Peak()))
+	line("gate_acquired_total", strconv.FormatUint(s.Acquired, 10))
+	line("gate_released_total", strconv.FormatUint(s.Released, 10))
+	line("gate_rejected_total", strconv.FormatUint(s.Rejected, 10))
+	line("gate_wait_seconds_total", strconv.FormatFloat(s.TotalWait.Seconds(), 'g', -1, 64))
+	return bw.Flush()
+}
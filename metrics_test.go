@@ -0,0 +1,24 @@
+package gate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	g := New(8)
+	g.Add(3)
+	var b strings.Builder
+	if err := g.WriteMetrics(&b, `x"y`); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"gate_held{name=\"x\\\"y\"} 3\n",
+		"gate_capacity{name=\"x\\\"y\"} 8\n",
+		"gate_acquired_total{name=\"x\\\"y\"} 3\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, b.String())
+		}
+	}
+}
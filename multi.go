@@ -0,0 +1,84 @@
+package gate
+
+import (
+	"sort"
+	"sync"
+)
+
+// LockAll locks all gates, one slot per gate occurrence. Gates are always
+// locked in the same canonical order regardless of the order of arguments,
+// so concurrent LockAll calls on overlapping sets of gates do not deadlock
+// each other. Gates should be unlocked with UnlockAll.
+func LockAll(gates ...*Gate) {
+	for _, g := range ordered(gates) {
+		g.Lock()
+	}
+}
+
+// UnlockAll unlocks gates locked by LockAll or TryLockAll, in reverse locking
+// order.
+func UnlockAll(gates ...*Gate) {
+	gates = ordered(gates)
+	for i := len(gates) - 1; i >= 0; i-- {
+		gates[i].Unlock()
+	}
+}
+
+// TryLockAll tries to lock all gates like LockAll does, but without blocking.
+// It either locks all gates and returns true, or locks none of them and
+// returns false.
+func TryLockAll(gates ...*Gate) bool {
+	gates = ordered(gates)
+	for i, g := range gates {
+		if !g.TryLock() {
+			UnlockAll(gates[:i]...)
+			return false
+		}
+	}
+	return true
+}
+
+// TryAcquireGroup is like TryLockAll, but on success it returns a function
+// unlocking all gates, so that caller does not need to pass the same gates
+// again. Only the first call of the returned function has effect. If not all
+// gates can be locked without blocking, none stay locked and ok is false.
+func TryAcquireGroup(gates ...*Gate) (release func(), ok bool) {
+	gates = ordered(gates)
+	if !TryLockAll(gates...) {
+		return nil, false
+	}
+	var once sync.Once
+	return func() { once.Do(func() { UnlockAll(gates...) }) }, true
+}
+
+// Merge returns new Gate with capacity equal to the sum of a and b
+// capacities, which already holds as many slots as a and b hold together,
+// and closes a and b with Close. Slots held on a and b must then be released
+// on the returned gate, and all references to a and b must be switched to
+// it. Since held slots can not be migrated while they are acquired or
+// released, no other goroutine may use a or b while Merge runs. Merge panics
+// if a and b are the same gate or if either of them is unlimited.
+func Merge(a, b *Gate) *Gate {
+	if a == b {
+		panic("gate: Merge of a gate with itself")
+	}
+	if a.unl != nil || b.unl != nil {
+		panic("gate: Merge of unlimited gate")
+	}
+	g := New(a.Cap() + b.Cap() + a.extra + b.extra)
+	g.extra = a.extra + b.extra
+	for i := a.Len() + b.Len(); i > 0; i-- {
+		g.c <- struct{}{}
+	}
+	a.Close()
+	b.Close()
+	return g
+}
+
+// ordered returns a copy of gates sorted in canonical locking order.
+func ordered(gates []*Gate) []*Gate {
+	out := make([]*Gate, len(gates))
+	copy(out, gates)
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
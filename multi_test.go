@@ -0,0 +1,33 @@
+package gate
+
+import "testing"
+
+func TestTryAcquireGroup(t *testing.T) {
+	a, b := New(1), New(1)
+	release, ok := TryAcquireGroup(a, b)
+	if !ok || a.Len() != 1 || b.Len() != 1 {
+		t.Fatal("TryAcquireGroup failed on free gates")
+	}
+	release()
+	release()
+	b.Lock()
+	if _, ok := TryAcquireGroup(a, b); ok {
+		t.Fatal("TryAcquireGroup succeeded with a full gate")
+	}
+	if n := a.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after failed TryAcquireGroup, want 0", n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(2), New(3)
+	a.Lock()
+	b.Add(2)
+	g := Merge(a, b)
+	if g.Cap() != 5 || g.Len() != 3 {
+		t.Fatalf("merged gate has %d of %d slots held, want 3 of 5", g.Len(), g.Cap())
+	}
+	if err := a.LockOrClosed(); err != ErrClosed {
+		t.Fatalf("LockOrClosed on merged gate returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,48 @@
+package gate
+
+import (
+	"context"
+	"time"
+)
+
+// AcquireOption configures AcquireOpts call.
+type AcquireOption func(*acquireConfig)
+
+type acquireConfig struct {
+	ctx     context.Context
+	timeout time.Duration
+}
+
+// WithContext makes AcquireOpts give up once ctx is done.
+func WithContext(ctx context.Context) AcquireOption {
+	return func(c *acquireConfig) { c.ctx = ctx }
+}
+
+// WithTimeout makes AcquireOpts give up after waiting for d. Non-positive d
+// means no timeout.
+func WithTimeout(d time.Duration) AcquireOption {
+	return func(c *acquireConfig) { c.timeout = d }
+}
+
+// AcquireOpts acquires n gate slots, blocking until all of them are available,
+// with behavior customized by options. If it gives up due to options, it
+// releases all slots acquired so far and returns context.Canceled or
+// context.DeadlineExceeded error. Single slot acquisitions behave like
+// LockContext, others like AcquireContext, including its error on n out of
+// range.
+func (g *Gate) AcquireOpts(n int, opts ...AcquireOption) error {
+	cfg := acquireConfig{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ctx := cfg.ctx
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	if n == 1 {
+		return g.LockContext(ctx)
+	}
+	return g.AcquireContext(ctx, n)
+}
@@ -0,0 +1,75 @@
+package gate
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool runs submitted functions on a bounded number of goroutines, keeping
+// functions waiting to run in a bounded queue.
+type Pool struct {
+	g     *Gate
+	tasks chan func()
+	done  chan struct{} // closed once all queued tasks are started
+
+	mu     sync.RWMutex // guards closed and closing of tasks
+	closed bool
+}
+
+// NewPool returns new Pool running up to workers functions concurrently and
+// queueing up to queue functions waiting for a free worker. If workers is
+// non-positive, NewPool would panic.
+func NewPool(workers, queue int) *Pool {
+	checkConcurrency(workers)
+	p := &Pool{
+		g:     New(workers),
+		tasks: make(chan func(), queue),
+		done:  make(chan struct{}),
+	}
+	go p.dispatch()
+	return p
+}
+
+func (p *Pool) dispatch() {
+	defer close(p.done)
+	for fn := range p.tasks {
+		p.g.Go(fn)
+	}
+}
+
+// Submit queues fn to be run in a separate goroutine once pool has a free
+// worker. It never blocks: if queue is full, Submit returns ErrQueueFull, and
+// once pool is shut down, it returns ErrClosed. Like with Gate.Go, panic in fn
+// crashes the program.
+func (p *Pool) Submit(fn func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	select {
+	case p.tasks <- fn:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops pool from accepting new functions and blocks until all
+// already submitted functions, both queued and running, complete. If ctx is
+// done first, Shutdown returns ctx.Err(), while remaining functions continue
+// to run in the background. Shutdown can be called multiple times.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.g.WaitContext(ctx)
+}
@@ -0,0 +1,53 @@
+package gate
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(1, 1)
+	release := make(chan struct{})
+	var ran atomic.Int64
+	task := func() {
+		<-release
+		ran.Add(1)
+	}
+	// the first task takes the only worker, the second one is taken off the
+	// queue by the dispatcher waiting for a worker
+	if err := p.Submit(task); err != nil {
+		t.Fatalf("Submit to idle pool returned %v", err)
+	}
+	for p.g.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Submit(task); err != nil {
+		t.Fatalf("Submit to busy pool returned %v", err)
+	}
+	for p.g.Blocked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Submit(task); err != nil {
+		t.Fatalf("Submit to queue returned %v", err)
+	}
+	if err := p.Submit(task); err != ErrQueueFull {
+		t.Fatalf("Submit to full queue returned %v, want %v", err, ErrQueueFull)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown with running tasks returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := p.Submit(task); err != ErrClosed {
+		t.Fatalf("Submit after Shutdown returned %v, want %v", err, ErrClosed)
+	}
+	close(release)
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if n := ran.Load(); n != 3 {
+		t.Fatalf("%d tasks ran, want 3", n)
+	}
+}
@@ -0,0 +1,41 @@
+package gate
+
+import "sync"
+
+// LockPreemptible locks gate like Acquire1 does, and additionally returns a
+// channel which is closed once another goroutine blocks in Lock or its
+// variants waiting for a slot. This allows long-running background work to
+// yield its slot to waiting work: it can watch preempted and, once it is
+// closed, finish early and call release. The signal is advisory, the slot is
+// not released until release is called. If goroutines already wait for a slot
+// when LockPreemptible acquires one, preempted is closed right away.
+func (g *Gate) LockPreemptible() (release func(), preempted <-chan struct{}) {
+	g.Lock()
+	g.preemptible.Add(1)
+	g.cmu.Lock()
+	if g.contended == nil {
+		g.contended = make(chan struct{})
+	}
+	c := g.contended
+	g.cmu.Unlock()
+	if g.waiters.Load() > 0 {
+		g.contend()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.preemptible.Add(-1)
+			g.Unlock()
+		})
+	}, c
+}
+
+// contend closes the channel returned as preempted by LockPreemptible.
+func (g *Gate) contend() {
+	g.cmu.Lock()
+	defer g.cmu.Unlock()
+	if g.contended != nil {
+		close(g.contended)
+		g.contended = nil
+	}
+}
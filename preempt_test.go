@@ -0,0 +1,30 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockPreemptible(t *testing.T) {
+	g := New(1)
+	release, preempted := g.LockPreemptible()
+	select {
+	case <-preempted:
+		t.Fatal("preempted closed without waiters")
+	default:
+	}
+	done := make(chan struct{})
+	go func() {
+		g.Lock()
+		g.Unlock()
+		close(done)
+	}()
+	select {
+	case <-preempted:
+	case <-time.After(time.Second):
+		t.Fatal("preempted not closed once another goroutine waits")
+	}
+	release()
+	release()
+	<-done
+}
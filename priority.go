@@ -0,0 +1,96 @@
+package gate
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// PriorityGate is a concurrency limiter like Gate, on which goroutines blocked
+// in Lock with higher priority acquire freed slots before goroutines with
+// lower priority. Goroutines of the same priority acquire slots in their
+// arrival order.
+//
+// Under sustained contention of high priority goroutines, lower priority ones
+// may wait indefinitely. If that is not acceptable, limit high priority load
+// with a separate Gate, so that some slots are always left for the rest.
+type PriorityGate struct {
+	mu      sync.Mutex
+	max     int
+	held    int
+	seq     uint64 // arrival counter ordering waiters of the same priority
+	waiting prioQueue
+}
+
+// NewPriority returns new PriorityGate with provided capacity. If capacity is
+// non-positive, NewPriority would panic.
+func NewPriority(max int) *PriorityGate {
+	if max <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	return &PriorityGate{max: max}
+}
+
+// Lock acquires a slot, blocking until one is free and no goroutines with
+// higher priority wait for it. Larger prio means higher priority.
+func (pg *PriorityGate) Lock(prio int) {
+	pg.mu.Lock()
+	if pg.held < pg.max && len(pg.waiting) == 0 {
+		pg.held++
+		pg.mu.Unlock()
+		return
+	}
+	w := &prioWaiter{prio: prio, seq: pg.seq, ready: make(chan struct{})}
+	pg.seq++
+	heap.Push(&pg.waiting, w)
+	pg.mu.Unlock()
+	<-w.ready
+}
+
+// Unlock releases a slot, handing it over to the highest priority waiter if
+// there is one. Unlock panics if no slots are held.
+func (pg *PriorityGate) Unlock() {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	if pg.held == 0 {
+		panic("gate: unlock of unheld gate")
+	}
+	if len(pg.waiting) == 0 {
+		pg.held--
+		return
+	}
+	close(heap.Pop(&pg.waiting).(*prioWaiter).ready)
+}
+
+// Len returns number of currently held slots.
+func (pg *PriorityGate) Len() int {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	return pg.held
+}
+
+type prioWaiter struct {
+	prio  int
+	seq   uint64
+	ready chan struct{} // closed once waiter is handed a slot
+}
+
+// prioQueue implements heap.Interface, popping waiters with the highest
+// priority first, and the earliest arrived of them.
+type prioQueue []*prioWaiter
+
+func (q prioQueue) Len() int { return len(q) }
+func (q prioQueue) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+func (q prioQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *prioQueue) Push(x any)   { *q = append(*q, x.(*prioWaiter)) }
+func (q *prioQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
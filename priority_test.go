@@ -0,0 +1,27 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityOrder(t *testing.T) {
+	pg := NewPriority(1)
+	pg.Lock(0)
+	order := make(chan int, 3)
+	for _, prio := range []int{1, 5, 3} {
+		prio := prio
+		go func() {
+			pg.Lock(prio)
+			order <- prio
+			pg.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond) // make sure goroutine is queued
+	}
+	pg.Unlock()
+	for _, want := range []int{5, 3, 1} {
+		if got := <-order; got != want {
+			t.Fatalf("goroutine with priority %d acquired slot, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package gate
+
+import (
+	"sync"
+	"time"
+)
+
+// rate spaces events at least interval apart.
+type rate struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time // time of the last granted or reserved event
+}
+
+// reserve reserves time for the next event and returns it.
+func (r *rate) reserve() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	t := r.last.Add(r.interval)
+	if t.Before(now) {
+		t = now
+	}
+	r.last = t
+	return t
+}
+
+// tryReserve reserves the next event if it can happen right now and reports
+// whether it succeeded.
+func (r *rate) tryReserve() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Before(r.last.Add(r.interval)) {
+		return false
+	}
+	r.last = now
+	return true
+}
+
+// cancel gives back event reserved at t, if no other events were reserved
+// after it.
+func (r *rate) cancel(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last.Equal(t) {
+		r.last = t.Add(-r.interval)
+	}
+}
+
+// wait reserves the next event and waits for its time, giving up, cancelling
+// reservation and returning false once done is closed.
+func (r *rate) wait(done <-chan struct{}) bool {
+	t := r.reserve()
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		r.cancel(t)
+		return false
+	}
+}
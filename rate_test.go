@@ -0,0 +1,31 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimited(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	g := NewRateLimited(10, interval)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		g.Lock()
+	}
+	if d := time.Since(start); d < 2*interval {
+		t.Fatalf("3 Lock calls took %v, want at least %v", d, 2*interval)
+	}
+	if g.TryLock() {
+		t.Fatal("TryLock succeeded before interval passed")
+	}
+	if n := g.Len(); n != 3 {
+		t.Fatalf("gate holds %d slots after failed TryLock, want 3", n)
+	}
+	time.Sleep(interval)
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after interval passed")
+	}
+	if err := g.Acquire(5); err != nil || g.Len() != 9 {
+		t.Fatalf("Acquire returned %v holding %d slots, want nil and 9", err, g.Len())
+	}
+}
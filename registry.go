@@ -0,0 +1,213 @@
+package gate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Registry holds a gate per key, created on first use, e.g. to limit
+// concurrency of each tenant separately. It is safe for concurrent use.
+type Registry struct {
+	defaultMax int
+	caps       map[string]int // per-key capacity overrides
+
+	mu    sync.Mutex
+	gates map[string]*Gate
+	calls map[string]*call // in-flight Do calls
+
+	fair *fairShare // set by WithSharedCapacity
+}
+
+// call is an in-flight or completed Registry.Do call.
+type call struct {
+	done chan struct{} // closed once val and err are set
+	val  any
+	err  error
+}
+
+// RegistryOption configures NewRegistry call.
+type RegistryOption func(*Registry)
+
+// WithKeyCapacity makes Registry create gate for key with capacity max
+// instead of the default one. If max is non-positive, WithKeyCapacity would
+// panic.
+func WithKeyCapacity(key string, max int) RegistryOption {
+	if max <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	return func(r *Registry) { r.caps[key] = max }
+}
+
+// NewRegistry returns new Registry creating gates with capacity defaultMax,
+// unless overridden for a key by options. If defaultMax is non-positive,
+// NewRegistry would panic.
+func NewRegistry(defaultMax int, opts ...RegistryOption) *Registry {
+	if defaultMax <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	r := &Registry{
+		defaultMax: defaultMax,
+		caps:       make(map[string]int),
+		gates:      make(map[string]*Gate),
+		calls:      make(map[string]*call),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Gate returns gate for key, creating it if it does not exist yet.
+func (r *Registry) Gate(key string) *Gate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok := r.gates[key]; ok {
+		return g
+	}
+	max, ok := r.caps[key]
+	if !ok {
+		max = r.defaultMax
+	}
+	g := New(max)
+	r.gates[key] = g
+	return g
+}
+
+// Do calls fn with gate for key locked and returns its results, deduplicating
+// concurrent calls for the same key: while one call for key is in flight,
+// other Do calls for that key do not call fn, but wait for it and return its
+// results. Distinct keys are limited by their gates as usual. If fn panics,
+// gate is unlocked, waiting calls return an error wrapping ErrPanicked, and
+// the panic is propagated to the caller which called fn.
+func (r *Registry) Do(key string, fn func() (any, error)) (any, error) {
+	r.mu.Lock()
+	if c, ok := r.calls[key]; ok {
+		r.mu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	r.calls[key] = c
+	r.mu.Unlock()
+	g := r.Gate(key)
+	defer func() {
+		if p := recover(); p != nil {
+			c.err = fmt.Errorf("%w: %v", ErrPanicked, p)
+			r.finish(key, c)
+			panic(p)
+		}
+		r.finish(key, c)
+	}()
+	g.Lock()
+	defer g.Unlock()
+	c.val, c.err = fn()
+	return c.val, c.err
+}
+
+// finish removes completed call from in-flight ones and wakes up its waiters.
+func (r *Registry) finish(key string, c *call) {
+	r.mu.Lock()
+	delete(r.calls, key)
+	r.mu.Unlock()
+	close(c.done)
+}
+
+// Remove removes gate for key from registry, so that the next Gate call
+// creates a new one. It is intended to evict idle keys: goroutines already
+// holding the removed gate keep using it, so if it is not idle, the key
+// briefly gets capacity of both the old and the new gate.
+func (r *Registry) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gates, key)
+}
+
+// ExpiringRegistry holds a gate per key like Registry does, but removes gates
+// which were idle and not requested with Gate for longer than configured
+// time to live, bounding memory use when keys come and go. Gates with held
+// slots are never removed. It is safe for concurrent use.
+type ExpiringRegistry struct {
+	defaultMax int
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	gates map[string]*expiring
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type expiring struct {
+	g    *Gate
+	used time.Time // last time gate was returned by Gate
+}
+
+// NewExpiringRegistry returns new ExpiringRegistry creating gates with
+// capacity defaultMax, and starts a goroutine removing gates idle for longer
+// than ttl, which runs until Close is called. A gate returned by Gate should
+// be locked well within ttl, otherwise it may be removed and a new gate
+// created for the same key. If defaultMax or ttl is non-positive,
+// NewExpiringRegistry would panic.
+func NewExpiringRegistry(defaultMax int, ttl time.Duration) *ExpiringRegistry {
+	if defaultMax <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	if ttl <= 0 {
+		panic("gate: non-positive NewExpiringRegistry ttl")
+	}
+	r := &ExpiringRegistry{
+		defaultMax: defaultMax,
+		ttl:        ttl,
+		gates:      make(map[string]*expiring),
+		stop:       make(chan struct{}),
+	}
+	go r.sweep()
+	return r
+}
+
+// Gate returns gate for key, creating it if it does not exist yet, and
+// refreshes its last use time.
+func (r *ExpiringRegistry) Gate(key string) *Gate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.gates[key]
+	if !ok {
+		e = &expiring{g: New(r.defaultMax)}
+		r.gates[key] = e
+	}
+	e.used = time.Now()
+	return e.g
+}
+
+// Len returns number of gates currently held by registry.
+func (r *ExpiringRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.gates)
+}
+
+// Close stops the goroutine removing idle gates. Gates already held by
+// registry stay there. Close is idempotent.
+func (r *ExpiringRegistry) Close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *ExpiringRegistry) sweep() {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+		r.mu.Lock()
+		for key, e := range r.gates {
+			if time.Since(e.used) > r.ttl && e.g.IsIdle() {
+				delete(r.gates, key)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,105 @@
+package gate
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(2, WithKeyCapacity("big", 5))
+	a := r.Gate("a")
+	if r.Gate("a") != a {
+		t.Fatal("Gate returned different gates for the same key")
+	}
+	if n := a.Cap(); n != 2 {
+		t.Fatalf("default gate capacity is %d, want 2", n)
+	}
+	if n := r.Gate("big").Cap(); n != 5 {
+		t.Fatalf("overridden gate capacity is %d, want 5", n)
+	}
+	r.Remove("a")
+	if r.Gate("a") == a {
+		t.Fatal("Gate returned removed gate")
+	}
+}
+
+func TestWithKeyCapacityInvalid(t *testing.T) {
+	for _, max := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("WithKeyCapacity with capacity %d did not panic", max)
+				}
+			}()
+			NewRegistry(1, WithKeyCapacity("k", max))
+		}()
+	}
+}
+
+func TestExpiringRegistry(t *testing.T) {
+	r := NewExpiringRegistry(1, 10*time.Millisecond)
+	defer r.Close()
+	r.Gate("idle")
+	r.Gate("busy").Lock()
+	time.Sleep(50 * time.Millisecond)
+	if n := r.Len(); n != 1 {
+		t.Fatalf("registry holds %d gates, want 1", n)
+	}
+	if r.Gate("busy").TryLock() {
+		t.Fatal("busy gate was replaced while held")
+	}
+}
+
+func TestRegistryDo(t *testing.T) {
+	r := NewRegistry(1)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	results := make(chan any)
+	for i := 0; i < 3; i++ {
+		go func() {
+			v, _ := r.Do("k", func() (any, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+			results <- v
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		if v := <-results; v != 42 {
+			t.Fatalf("Do returned %v, want 42", v)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fn called %d times, want 1", n)
+	}
+}
+
+func TestRegistryDoPanic(t *testing.T) {
+	r := NewRegistry(1)
+	started := make(chan struct{})
+	follower := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		r.Do("k", func() (any, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := r.Do("k", func() (any, error) { return nil, nil })
+		follower <- err
+	}()
+	if err := <-follower; !errors.Is(err, ErrPanicked) {
+		t.Fatalf("follower got %v, want ErrPanicked", err)
+	}
+	if !r.Gate("k").TryLock() {
+		t.Fatal("gate slot was not released after panic")
+	}
+}
@@ -0,0 +1,47 @@
+package gate
+
+import "sync"
+
+// Reservation is a set of gate slots set aside by Reserve for later use.
+type Reservation struct {
+	g    *Gate
+	mu   sync.Mutex
+	left int // reserved slots not yet claimed with Use
+}
+
+// Reserve acquires n gate slots like Acquire does, blocking until they are
+// free, and sets them aside for later use with Reservation.Use, so that no
+// other callers can take them. Reserved slots are counted as held by Len and
+// are not available, while Cap is not affected. Reserve returns an error
+// wrapping ErrCapacity if n is negative or exceeds gate capacity.
+func (g *Gate) Reserve(n int) (*Reservation, error) {
+	if err := g.Acquire(n); err != nil {
+		return nil, err
+	}
+	return &Reservation{g: g, left: n}, nil
+}
+
+// Use claims one reserved slot and returns a function releasing it back to
+// gate. Only the first call of the returned function has effect. Use panics if
+// all reserved slots were already claimed or reservation was canceled.
+func (r *Reservation) Use() (release func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.left == 0 {
+		panic("gate: no reserved slots left")
+	}
+	r.left--
+	var once sync.Once
+	return func() { once.Do(r.g.Unlock) }
+}
+
+// Cancel releases reserved slots not yet claimed with Use back to gate. Slots
+// already claimed stay held until released. Cancel is idempotent.
+func (r *Reservation) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.left > 0 {
+		r.g.Release(r.left)
+		r.left = 0
+	}
+}
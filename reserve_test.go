@@ -0,0 +1,29 @@
+package gate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	g := New(3)
+	if _, err := g.Reserve(4); !errors.Is(err, ErrCapacity) {
+		t.Fatalf("Reserve over capacity returned %v, want ErrCapacity", err)
+	}
+	r, err := g.Reserve(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := g.Available(); n != 1 {
+		t.Fatalf("%d slots available with 2 reserved, want 1", n)
+	}
+	release := r.Use()
+	r.Cancel()
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after Cancel, want 1", n)
+	}
+	release()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after release, want 0", n)
+	}
+}
@@ -0,0 +1,60 @@
+package gate
+
+import "context"
+
+// Retire waits like WaitContext does until all held slots are released, then
+// permanently disables gate: from then on Lock, Unlock, Add and their
+// variants return immediately without doing anything, and non-blocking ones
+// like TryLock succeed, so leftover references to the gate never block nor
+// panic. This allows to remove a limiter from a running system, e.g. when
+// tearing down a feature flag, while some code may still use it. Retired
+// gate reports Len of 0 and Available equal to its capacity, Wait and its
+// variants return immediately, and Resize is a no-op. Unlike Close, which
+// makes LockOrClosed reject callers, Retire lets all callers through.
+//
+// If ctx is done before gate drains, Retire returns ctx.Err() and gate stays
+// enabled. Acquisitions which wait for a slot when gate is retired return
+// without acquiring it. Retired gate keeps all its slots to itself, so the
+// channel returned by C never accepts sends, and parent slots of a child
+// gate, see NewChild, are no longer acquired. Retire is idempotent.
+func (g *Gate) Retire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !g.lockm(ctx.Done()) {
+		return ctx.Err()
+	}
+	defer g.unlockm()
+	if g.retired.Load() {
+		return nil
+	}
+	if g.unl != nil {
+		if !g.unl.wait(ctx.Done()) {
+			return ctx.Err()
+		}
+		g.retired.Store(true)
+		return nil
+	}
+	// acquire all slots and never release them, so that goroutines which
+	// start acquisition before noticing gate is retired can not get a slot
+	for i := 0; i < cap(g.c); i++ {
+		select {
+		case g.c <- struct{}{}:
+		case <-ctx.Done():
+			for ; i > 0; i-- {
+				<-g.c
+			}
+			g.freed()
+			return ctx.Err()
+		}
+	}
+	g.retired.Store(true)
+	// wake up goroutines blocked on sending to the slots channel, so that
+	// they notice gate is retired
+	g.rw.Lock()
+	close(g.swap)
+	g.swap = make(chan struct{})
+	g.rw.Unlock()
+	g.freed()
+	return nil
+}
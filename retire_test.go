@@ -0,0 +1,106 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetire(t *testing.T) {
+	g := New(2)
+	g.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Retire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Retire returned %v, want context.DeadlineExceeded", err)
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after canceled Retire, want 1", n)
+	}
+	if !g.TryLock() {
+		t.Fatal("TryLock failed after canceled Retire")
+	}
+	g.Add(-2)
+	if err := g.Retire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		g.Lock()
+	}
+	g.Add(2)
+	if !g.TryLock() {
+		t.Fatal("TryLock failed on retired gate")
+	}
+	if g.Len() != 0 || g.Available() != 2 {
+		t.Fatalf("retired gate has %d held and %d free slots, want 0 and 2", g.Len(), g.Available())
+	}
+	g.Unlock()
+	g.Add(-2)
+	g.Wait()
+}
+
+func TestRetireWakesWaiters(t *testing.T) {
+	g := New(1)
+	g.Lock()
+	locked := make(chan struct{})
+	go func() {
+		g.Lock()
+		close(locked)
+	}()
+	for g.Blocked() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	retired := make(chan error)
+	go func() { retired <- g.Retire(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	g.Unlock()
+	// the waiter may take the released slot before Retire does, in which
+	// case Retire waits for it to be released too
+	select {
+	case <-locked:
+		g.Unlock()
+	case err := <-retired:
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-locked
+		return
+	}
+	if err := <-retired; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetireChild(t *testing.T) {
+	parent := New(2)
+	g := NewChild(parent, 2)
+	if err := g.Retire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		g.Lock()
+		if err := g.LockOrReject(); err != nil {
+			t.Fatalf("LockOrReject on retired child returned %v", err)
+		}
+		g.LockQueued()
+		g.Unlock()
+	}
+	if n := parent.Len(); n != 0 {
+		t.Fatalf("parent holds %d slots taken by retired child, want 0", n)
+	}
+}
+
+func TestRetireBounded(t *testing.T) {
+	g := NewBounded(1, 0)
+	if err := g.Retire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := g.LockOrReject(); err != nil {
+			t.Fatalf("LockOrReject on retired bounded gate returned %v", err)
+		}
+		if n := g.LockQueued(); n != 0 {
+			t.Fatalf("LockQueued on retired gate returned %d, want 0", n)
+		}
+	}
+}
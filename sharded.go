@@ -0,0 +1,54 @@
+package gate
+
+import "hash/fnv"
+
+// ShardedGate spreads a concurrency limit over several gates, choosing gate
+// by key hash, to reduce contention on a single gate under very high
+// throughput. Every key always maps to the same shard, so each key is limited
+// by its shard capacity, and total number of holders never exceeds total
+// capacity. However the limit is only approximate: callers are blocked once
+// their shard is full even if other shards have free slots, so the effective
+// global limit depends on key distribution.
+type ShardedGate struct {
+	gates []*Gate
+}
+
+// NewSharded returns new ShardedGate with totalMax capacity split among
+// shards gates as evenly as possible. NewSharded panics if shards is
+// non-positive or totalMax is less than shards.
+func NewSharded(totalMax, shards int) *ShardedGate {
+	if shards <= 0 || totalMax < shards {
+		panic("gate: invalid NewSharded capacity")
+	}
+	s := &ShardedGate{gates: make([]*Gate, shards)}
+	for i := range s.gates {
+		max := totalMax / shards
+		if i < totalMax%shards {
+			max++
+		}
+		s.gates[i] = New(max)
+	}
+	return s
+}
+
+// Lock locks gate of the shard key maps to.
+func (s *ShardedGate) Lock(key string) { s.shard(key).Lock() }
+
+// Unlock unlocks gate of the shard key maps to. It must be called with the
+// same key as the matching Lock.
+func (s *ShardedGate) Unlock(key string) { s.shard(key).Unlock() }
+
+// Len returns number of currently held slots over all shards.
+func (s *ShardedGate) Len() int {
+	var n int
+	for _, g := range s.gates {
+		n += g.Len()
+	}
+	return n
+}
+
+func (s *ShardedGate) shard(key string) *Gate {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.gates[h.Sum32()%uint32(len(s.gates))]
+}
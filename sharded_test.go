@@ -0,0 +1,24 @@
+package gate
+
+import "testing"
+
+func TestSharded(t *testing.T) {
+	s := NewSharded(10, 3)
+	var total int
+	for _, g := range s.gates {
+		total += g.Cap()
+	}
+	if total != 10 {
+		t.Fatalf("shards capacity totals %d, want 10", total)
+	}
+	s.Lock("a")
+	s.Lock("b")
+	if n := s.Len(); n != 2 {
+		t.Fatalf("sharded gate holds %d slots, want 2", n)
+	}
+	s.Unlock("a")
+	s.Unlock("b")
+	if n := s.Len(); n != 0 {
+		t.Fatalf("sharded gate holds %d slots after Unlock, want 0", n)
+	}
+}
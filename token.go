@@ -0,0 +1,78 @@
+package gate
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Token represents ownership of a single gate slot. It is a value that can be
+// passed around to make it explicit which code is responsible for releasing
+// the slot. Zero Token does not represent any slot.
+type Token struct {
+	g     *Gate
+	state *atomic.Int32 // one of tokenHeld, tokenReleased, tokenTransferred
+	taken time.Time     // set if gate has hold observer
+}
+
+const (
+	tokenHeld int32 = iota
+	tokenReleased
+	tokenTransferred
+)
+
+// Take locks gate and returns Token representing acquired slot.
+func (g *Gate) Take() Token {
+	g.Lock()
+	t := Token{g: g, state: new(atomic.Int32)}
+	if g.holdObs != nil {
+		t.taken = time.Now()
+	}
+	return t
+}
+
+// NewWithHoldObserver returns new Gate with provided capacity, which calls obs
+// with the time a slot was held each time a Token returned by Take is
+// released, however many times it was transferred in between. Only slots
+// acquired with Take are measured, since slots acquired with Lock and its
+// variants can not be paired with their Unlock calls. obs is called from the
+// releasing goroutine, so it should be fast. If capacity is non-positive,
+// NewWithHoldObserver would panic.
+func NewWithHoldObserver(max int, obs func(held time.Duration)) *Gate {
+	g := New(max)
+	g.holdObs = obs
+	return g
+}
+
+// Release releases the slot represented by the token. Token copies share the
+// slot, so only one of them may be released: releasing the same slot twice
+// panics, as does releasing zero Token or a token which was transferred.
+func (t Token) Release() {
+	if t.g == nil {
+		panic("gate: release of zero Token")
+	}
+	switch t.state.Swap(tokenReleased) {
+	case tokenReleased:
+		panic("gate: Token released twice")
+	case tokenTransferred:
+		panic("gate: release of transferred Token")
+	}
+	t.g.Unlock()
+	if !t.taken.IsZero() {
+		t.g.holdObs(time.Since(t.taken))
+	}
+}
+
+// TransferTo hands ownership of the slot over to the returned token, e.g. to
+// pass it to the goroutine that completes the work. The slot is released by
+// calling Release on the returned token, while t and its copies become
+// invalid: releasing or transferring them again panics. However many times
+// the slot is handed over, it is released exactly once.
+func (t Token) TransferTo() Token {
+	if t.g == nil {
+		panic("gate: transfer of zero Token")
+	}
+	if !t.state.CompareAndSwap(tokenHeld, tokenTransferred) {
+		panic("gate: transfer of released or transferred Token")
+	}
+	return Token{g: t.g, state: new(atomic.Int32), taken: t.taken}
+}
@@ -0,0 +1,38 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenTransfer(t *testing.T) {
+	g := New(1)
+	a := g.Take()
+	b := a.TransferTo().TransferTo()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("release of transferred token did not panic")
+			}
+		}()
+		a.Release()
+	}()
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots, want 1", n)
+	}
+	b.Release()
+	if n := g.Len(); n != 0 {
+		t.Fatalf("gate holds %d slots after release, want 0", n)
+	}
+}
+
+func TestHoldObserver(t *testing.T) {
+	var held time.Duration
+	g := NewWithHoldObserver(1, func(d time.Duration) { held = d })
+	tok := g.Take().TransferTo()
+	time.Sleep(10 * time.Millisecond)
+	tok.Release()
+	if held < 10*time.Millisecond {
+		t.Fatalf("observed hold time %v, want at least 10ms", held)
+	}
+}
@@ -0,0 +1,207 @@
+package gate
+
+import (
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracker records call sites of held slots for HeldBy.
+type tracker struct {
+	mu   sync.Mutex
+	seq  uint64
+	held map[uint64]heldSlot // by acquisition sequence number
+}
+
+type heldSlot struct {
+	goid uint64    // id of acquiring goroutine
+	site string    // file:line of acquiring call
+	at   time.Time // acquisition time
+}
+
+// SetTrackCallers enables or disables recording of call sites acquiring gate
+// slots, reported by HeldBy. This is a debugging aid to find where leaked
+// slots were acquired; it makes every acquisition and release much slower and
+// is disabled by default, costing nothing then. Slots held when tracking is
+// enabled are not tracked.
+func (g *Gate) SetTrackCallers(enabled bool) {
+	if !enabled {
+		g.track.Store(nil)
+		return
+	}
+	g.track.Store(&tracker{held: make(map[uint64]heldSlot)})
+}
+
+// HeldBy returns call sites, as file:line, which acquired currently held gate
+// slots, the oldest first, if enabled by SetTrackCallers. Since slots are not
+// distinguishable, release is attributed to the last slot acquired by the
+// releasing goroutine, or, if it has none, like when slot was handed over to
+// another goroutine, to the oldest held slot, so in such cases reported call
+// sites are approximate. Slots acquired with LockFast or by sending to the
+// channel returned by C are not tracked.
+func (g *Gate) HeldBy() []string {
+	t := g.track.Load()
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seqs := make([]uint64, 0, len(t.held))
+	for seq := range t.held {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	out := make([]string, len(seqs))
+	for i, seq := range seqs {
+		out[i] = t.held[seq].site
+	}
+	return out
+}
+
+// HeldAges returns for how long currently held gate slots have been held, the
+// longest first, in the same order as HeldBy reports their call sites, if
+// enabled by SetTrackCallers, otherwise it returns nil. Like HeldBy, it is a
+// debugging aid, e.g. to find slots held by hung workers, and the same
+// approximations apply.
+func (g *Gate) HeldAges() []time.Duration {
+	t := g.track.Load()
+	if t == nil {
+		return nil
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]time.Duration, 0, len(t.held))
+	for _, s := range t.held {
+		out = append(out, now.Sub(s.at))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	return out
+}
+
+func (t *tracker) acquired(n int) {
+	s := heldSlot{goid: goid(), site: callSite(), at: time.Now()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := 0; i < n; i++ {
+		t.seq++
+		t.held[t.seq] = s
+	}
+}
+
+func (t *tracker) released(n int) {
+	id := goid()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ; n > 0 && len(t.held) > 0; n-- {
+		var own, oldest uint64
+		for seq, s := range t.held {
+			if s.goid == id && seq > own {
+				own = seq
+			}
+			if oldest == 0 || seq < oldest {
+				oldest = seq
+			}
+		}
+		if own != 0 {
+			delete(t.held, own)
+		} else {
+			delete(t.held, oldest)
+		}
+	}
+}
+
+// owners counts slots held by each goroutine for SetOwnerCheck.
+type owners struct {
+	mu   sync.Mutex
+	held map[uint64]int // by goroutine id
+}
+
+// SetOwnerCheck enables or disables checking that goroutines release only
+// slots they acquired themselves: once enabled, Unlock, Release and their
+// variants panic if the calling goroutine does not hold enough slots acquired
+// after the check was enabled. This is a diagnostic aid for tests, e.g. to
+// turn an unmatched Unlock into a panic at the offending call, not a
+// correctness guarantee: goroutine ids are obtained with a runtime trick, and
+// the check makes every acquisition and release much slower. It is disabled
+// by default. Code handing slots over to other goroutines, like Go, Pool or
+// Token.TransferTo do, fails the check, so it must not be enabled on gates
+// used that way.
+func (g *Gate) SetOwnerCheck(enabled bool) {
+	if !enabled {
+		g.owners.Store(nil)
+		return
+	}
+	g.owners.Store(&owners{held: make(map[uint64]int)})
+}
+
+func (o *owners) acquired(n int) {
+	id := goid()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.held[id] += n
+}
+
+// reset forgets all held slots, once they were released with Reset.
+func (o *owners) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	clear(o.held)
+}
+
+// released accounts release of n slots by the current goroutine, panicking
+// if it does not hold them.
+func (o *owners) released(n int) {
+	id := goid()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	held := o.held[id]
+	if held < n {
+		panic("gate: goroutine " + strconv.FormatUint(id, 10) + " releases " +
+			strconv.Itoa(n) + " slots, but holds " + strconv.Itoa(held))
+	}
+	if held == n {
+		delete(o.held, id)
+	} else {
+		o.held[id] = held - n
+	}
+}
+
+// pkgDir is the directory of this package source files.
+var pkgDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callSite returns file:line of the innermost caller outside of this
+// package.
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		if filepath.Dir(f.File) != pkgDir || strings.HasSuffix(f.File, "_test.go") {
+			return f.File + ":" + strconv.Itoa(f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// goid returns id of the current goroutine.
+func goid() uint64 {
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	// b starts with "goroutine 123 [running]:"
+	b = b[len("goroutine "):]
+	if i := strings.IndexByte(string(b), ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
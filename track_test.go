@@ -0,0 +1,83 @@
+package gate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeldBy(t *testing.T) {
+	g := New(3)
+	g.SetTrackCallers(true)
+	g.Lock()
+	g.Add(1)
+	sites := g.HeldBy()
+	if len(sites) != 2 {
+		t.Fatalf("HeldBy returned %v, want 2 call sites", sites)
+	}
+	for _, s := range sites {
+		if !strings.Contains(s, "track_test.go:") {
+			t.Fatalf("HeldBy returned %q, want call site in track_test.go", s)
+		}
+	}
+	g.Unlock()
+	g.Unlock()
+	if sites := g.HeldBy(); len(sites) != 0 {
+		t.Fatalf("HeldBy returned %v after release, want none", sites)
+	}
+}
+
+func TestOwnerCheck(t *testing.T) {
+	g := New(2)
+	g.SetOwnerCheck(true)
+	g.Lock()
+	g.Unlock()
+	g.Lock()
+	panicked := make(chan bool)
+	go func() {
+		defer func() { panicked <- recover() != nil }()
+		g.Unlock()
+	}()
+	if !<-panicked {
+		t.Fatal("Unlock by goroutine not holding a slot did not panic")
+	}
+	if n := g.Len(); n != 1 {
+		t.Fatalf("gate holds %d slots after rejected Unlock, want 1", n)
+	}
+	g.Unlock()
+}
+
+func TestHeldAges(t *testing.T) {
+	g := New(2)
+	if ages := g.HeldAges(); ages != nil {
+		t.Fatalf("HeldAges returned %v with tracking disabled, want nil", ages)
+	}
+	g.SetTrackCallers(true)
+	g.Lock()
+	time.Sleep(10 * time.Millisecond)
+	g.Lock()
+	ages := g.HeldAges()
+	if len(ages) != 2 || ages[0] < 10*time.Millisecond || ages[1] >= ages[0] {
+		t.Fatalf("HeldAges returned %v, want two ages, the first at least 10ms", ages)
+	}
+	g.Add(-2)
+}
+
+func TestHeldByUnlockSafe(t *testing.T) {
+	g := New(2)
+	g.SetTrackCallers(true)
+	g.SetOwnerCheck(true)
+	g.Lock()
+	if err := g.UnlockSafe(); err != nil {
+		t.Fatal(err)
+	}
+	if sites := g.HeldBy(); len(sites) != 0 {
+		t.Fatalf("HeldBy returned %v after UnlockSafe, want none", sites)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("UnlockSafe by goroutine not holding a slot did not panic with owner check")
+		}
+	}()
+	g.UnlockSafe()
+}
@@ -0,0 +1,92 @@
+package gate
+
+import "sync"
+
+// counter tracks number of held slots of a gate without limiting it, unless
+// it has a backend which limits them instead.
+type counter struct {
+	backend Backend // set by NewWithBackend
+
+	mu      sync.Mutex
+	n       int
+	changed chan struct{} // if non-nil, closed on the next n change
+}
+
+// add adds delta to the counter, delta may be negative. If delta is "unlocking"
+// more slots than currently held, counter is left intact and add panics.
+// Positive delta must be acquired from backend first, negative delta is
+// released to backend by add.
+func (c *counter) add(delta int) {
+	c.mu.Lock()
+	if c.n+delta < 0 {
+		c.mu.Unlock()
+		panic("gate: unlock of unheld gate")
+	}
+	c.set(c.n + delta)
+	c.mu.Unlock()
+	c.releaseBackend(-delta)
+}
+
+// tryRelease decrements counter and reports whether it was positive.
+func (c *counter) tryRelease() bool {
+	c.mu.Lock()
+	if c.n == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	c.set(c.n - 1)
+	c.mu.Unlock()
+	c.releaseBackend(1)
+	return true
+}
+
+// reset sets counter to zero returning its previous value.
+func (c *counter) reset() int {
+	c.mu.Lock()
+	n := c.n
+	c.set(0)
+	c.mu.Unlock()
+	c.releaseBackend(n)
+	return n
+}
+
+// set sets counter to n waking up waiters, c.mu must be held.
+func (c *counter) set(n int) {
+	c.n = n
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+func (c *counter) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// wait blocks until counter is zero, giving up and returning false once done
+// is closed.
+func (c *counter) wait(done <-chan struct{}) bool { return c.waitBelow(0, done) }
+
+// waitBelow blocks until counter is no more than n, giving up and returning
+// false once done is closed.
+func (c *counter) waitBelow(n int, done <-chan struct{}) bool {
+	for {
+		c.mu.Lock()
+		if c.n <= n {
+			c.mu.Unlock()
+			return true
+		}
+		if c.changed == nil {
+			c.changed = make(chan struct{})
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-done:
+			return false
+		}
+	}
+}
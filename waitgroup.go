@@ -0,0 +1,36 @@
+package gate
+
+// WaitGroup is a drop-in replacement for sync.WaitGroup in common use cases,
+// which additionally bounds its counter.
+//
+// Unlike sync.WaitGroup, WaitGroup.Add blocks while adding to the counter
+// would make it exceed the capacity set by NewWaitGroup, until enough Done
+// calls happen. Add also panics if its argument is greater than capacity,
+// since such call could never proceed. This is the deliberate divergence from
+// sync.WaitGroup, which lets code written against it bound its concurrency
+// without changes other than the WaitGroup construction.
+type WaitGroup struct {
+	g *Gate
+}
+
+// NewWaitGroup returns new WaitGroup with counter bounded by max. If max is
+// non-positive, NewWaitGroup would panic.
+func NewWaitGroup(max int) *WaitGroup {
+	checkConcurrency(max)
+	return &WaitGroup{g: New(max)}
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter, blocking
+// while counter would exceed WaitGroup capacity.
+func (wg *WaitGroup) Add(delta int) { wg.g.Add(delta) }
+
+// Done decrements the WaitGroup counter by one.
+func (wg *WaitGroup) Done() { wg.g.Done() }
+
+// Wait blocks until the WaitGroup counter is zero.
+func (wg *WaitGroup) Wait() { wg.g.Wait() }
+
+// Go calls f in a new goroutine and adds that task to the WaitGroup, blocking
+// until counter has room for it. When f returns, the task is removed from the
+// WaitGroup.
+func (wg *WaitGroup) Go(f func()) { wg.g.Go(f) }
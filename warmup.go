@@ -0,0 +1,40 @@
+package gate
+
+import "time"
+
+// NewWarmup returns new Gate which starts with capacity 1 and ramps it up to
+// max over the warmup duration, to avoid hammering cold downstream services
+// right after startup. Capacity doubles at equal intervals, reaching max at
+// the end of warmup, after which gate is a normal gate of capacity max: e.g.
+// for max 10 and warmup of 4s, capacity is 1, 2, 4, 8 and 10 after 0, 1, 2, 3
+// and 4 seconds. Cap and Available reflect the current capacity. Ramp is done
+// with Resize from a timer, so calling Resize during warmup only sets
+// capacity until the next step. If max is non-positive, NewWarmup would
+// panic.
+func NewWarmup(max int, warmup time.Duration) *Gate {
+	if max <= 0 {
+		panic("gate: non-positive capacity")
+	}
+	g := New(1)
+	var steps int
+	for n := 1; n < max; n *= 2 {
+		steps++
+	}
+	if steps == 0 || warmup <= 0 {
+		g.Resize(max)
+		return g
+	}
+	interval := warmup / time.Duration(steps)
+	var step func(n int)
+	step = func(n int) {
+		if n *= 2; n > max {
+			n = max
+		}
+		g.Resize(n)
+		if n < max {
+			time.AfterFunc(interval, func() { step(n) })
+		}
+	}
+	time.AfterFunc(interval, func() { step(1) })
+	return g
+}
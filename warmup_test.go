@@ -0,0 +1,20 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	g := NewWarmup(5, 30*time.Millisecond)
+	if n := g.Cap(); n != 1 {
+		t.Fatalf("initial capacity is %d, want 1", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for g.Cap() != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("capacity is %d after warmup, want 5", g.Cap())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
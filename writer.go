@@ -0,0 +1,51 @@
+package gate
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// BoundedWriters returns a writer which writes to all ws concurrently,
+// holding a slot of g for each underlying write, so no more than g.Cap()
+// writes run at the same time. Its Write returns only once all underlying
+// writes complete, so p must stay unchanged until then, just as io.Writer
+// requires. If any underlying write fails or writes less than len(p) bytes,
+// Write returns the smallest number of bytes written by any writer and all
+// errors joined; short writes without an error are reported as
+// io.ErrShortWrite.
+func BoundedWriters(g *Gate, ws ...io.Writer) io.Writer {
+	return &boundedWriter{g: g, ws: ws}
+}
+
+type boundedWriter struct {
+	g  *Gate
+	ws []io.Writer
+}
+
+func (bw *boundedWriter) Write(p []byte) (int, error) {
+	ns := make([]int, len(bw.ws))
+	errs := make([]error, len(bw.ws))
+	var wg sync.WaitGroup
+	for i, w := range bw.ws {
+		bw.g.Lock()
+		wg.Add(1)
+		i, w := i, w
+		go func() {
+			defer wg.Done()
+			defer bw.g.Unlock()
+			ns[i], errs[i] = w.Write(p)
+			if errs[i] == nil && ns[i] < len(p) {
+				errs[i] = io.ErrShortWrite
+			}
+		}()
+	}
+	wg.Wait()
+	n := len(p)
+	for _, k := range ns {
+		if k < n {
+			n = k
+		}
+	}
+	return n, errors.Join(errs...)
+}
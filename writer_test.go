@@ -0,0 +1,27 @@
+package gate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 1, errors.New("fail") }
+
+func TestBoundedWriters(t *testing.T) {
+	var a, b bytes.Buffer
+	w := BoundedWriters(New(1), &a, &b)
+	if n, err := io.WriteString(w, "hello"); n != 5 || err != nil {
+		t.Fatalf("Write returned %d, %v; want 5, nil", n, err)
+	}
+	if a.String() != "hello" || b.String() != "hello" {
+		t.Fatalf("writers got %q and %q, want \"hello\"", a.String(), b.String())
+	}
+	w = BoundedWriters(New(2), &a, failWriter{})
+	if n, err := io.WriteString(w, "hello"); n != 1 || err == nil {
+		t.Fatalf("Write returned %d, %v; want 1 and an error", n, err)
+	}
+}